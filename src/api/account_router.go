@@ -0,0 +1,204 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"speechToText/src/auth"
+	"speechToText/src/cache"
+	"speechToText/src/db"
+	"speechToText/src/export"
+	"speechToText/src/service"
+	"speechToText/src/types"
+)
+
+// Me godoc
+// @Summary Current user
+// @Description Returns the logged-in user with a summary of their usage
+// @Tags account
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} types.ProfileResponse "Profile"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /me [get]
+func (h *Handlers) Me(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	profile, err := h.store.GetProfile(principal.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, profile)
+}
+
+// ChangePassword godoc
+// @Summary Change password
+// @Description Changes the password after checking the current one. Every other session and refresh token of the user stops working; the current one stays logged in.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body types.PasswordChangeRequest true "Current and new password"
+// @Success 200 {object} map[string]string "Password changed"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Current password is wrong"
+// @Failure 500 {string} string "Internal server error"
+// @Router /me/password [post]
+func (h *Handlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	ctx := r.Context()
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.PasswordChangeRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.CurrentPassword == "" {
+		http.Error(w, "current_password is required", http.StatusBadRequest)
+		return
+	}
+	if err = service.ValidatePassword(request.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, valid, err := h.store.CheckAuthData(principal.Username, request.CurrentPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !valid {
+		http.Error(w, "current password is wrong", http.StatusForbidden)
+		return
+	}
+	hashPassword, err := db.HashPassword(request.NewPassword)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	keepFamily := ""
+	if principal.Method == auth.MethodToken {
+		keepFamily = principal.SessionID
+	}
+	version, err := h.store.ChangePassword(principal.Username, hashPassword, keepFamily)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	keepSession := ""
+	if principal.Method == auth.MethodSession {
+		keepSession = principal.SessionID
+		session, err := h.session.SessionGet(ctx, r)
+		if err == nil {
+			err = session.Set(ctx, auth.SessionVersionKey, version)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	// The new session version already locks the other sessions out; delete
+	// them so they disappear from the session list.
+	if _, err := h.session.RevokeSessions(ctx, principal.Username, keepSession); err != nil {
+		service.LogError("revoke sessions after password change: %v", err)
+	}
+	writeJSON(w, map[string]string{"result": "ok"})
+}
+
+// DeleteAccount godoc
+// @Summary Delete account
+// @Description Deletes the account after checking its password, together with all tasks, their audio sources and transcripts, exports, API keys, webhooks, sessions and event history. Tasks still being transcribed are cancelled. This cannot be undone.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body types.DeleteAccountRequest true "Password"
+// @Success 200 {object} map[string]string "Account deleted"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Password is wrong"
+// @Failure 500 {string} string "Internal server error"
+// @Router /me [delete]
+func (h *Handlers) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.DeleteAccountRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Password == "" {
+		http.Error(w, "password is required", http.StatusBadRequest)
+		return
+	}
+	if _, valid, err := h.store.CheckAuthData(principal.Username, request.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !valid {
+		http.Error(w, "password is wrong", http.StatusForbidden)
+		return
+	}
+	exports, unfinished, err := h.store.DeleteAccount(principal.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Workers still on these tasks would keep paying for transcriptions
+	// nobody can fetch.
+	for _, taskID := range unfinished {
+		if err := h.pubsub.PublishCancel(r.Context(), taskID); err != nil {
+			service.LogError("cancel task %s of deleted user: %v", taskID, err)
+		}
+	}
+	if err := cache.DeleteUserData(r.Context(), h.pubsub.Client, principal.Username); err != nil {
+		service.LogError("delete cached data of deleted user: %v", err)
+	}
+	for _, info := range exports {
+		if err := h.files.Remove(export.Key(info.ExportID, info.Format)); err != nil {
+			service.LogError("remove export %s of deleted user: %v", info.ExportID, err)
+		}
+	}
+	if _, err := h.session.RevokeSessions(r.Context(), principal.Username, ""); err != nil {
+		service.LogError("revoke sessions of deleted user: %v", err)
+	}
+	if principal.Method == auth.MethodSession {
+		if err := h.session.SessionDestroy(r.Context(), w, principal.SessionID); err != nil {
+			service.LogError("destroy session of deleted user: %v", err)
+		}
+	}
+	writeJSON(w, map[string]string{"result": "ok"})
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"speechToText/src/auth"
+	"speechToText/src/config"
+	"speechToText/src/consumer"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultAdminListSize = 50
+	maxAdminListSize     = 500
+)
+
+// adminPage reads the limit and offset parameters of the admin lists,
+// ignoring invalid values.
+func adminPage(values url.Values) (limit int, offset int) {
+	limit = defaultAdminListSize
+	if l, err := strconv.Atoi(values.Get("limit")); err == nil && l > 0 && l <= maxAdminListSize {
+		limit = l
+	}
+	if o, err := strconv.Atoi(values.Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+	return limit, offset
+}
+
+// AdminUsers godoc
+// @Summary List users
+// @Description Returns users ordered by username with their role, status and task count. Available to admins and support.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param search query string false "Part of the username, case-insensitive"
+// @Param role query string false "Only users with this role" Enums(user, admin, support)
+// @Param limit query int false "Maximum number of users" default(50)
+// @Param offset query int false "Number of users to skip" default(0)
+// @Success 200 {object} types.UserListResponse "Users"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/users [get]
+func (h *Handlers) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	values := r.URL.Query()
+	query := types.UserQuery{Search: values.Get("search"), Role: values.Get("role")}
+	if query.Role != "" && !slices.Contains(types.Roles, query.Role) {
+		http.Error(w, "unknown role", http.StatusBadRequest)
+		return
+	}
+	query.Limit, query.Offset = adminPage(values)
+	users, err := h.store.ListUsers(principal.Username, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.UserListResponse{Users: users})
+}
+
+// DisableUser godoc
+// @Summary Disable an account
+// @Description Blocks the user from logging in and revokes their refresh tokens. Sessions and API keys stop working immediately; access tokens at the latest when they expire. Admins only.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param username path string true "Username"
+// @Success 200 {object} map[string]string "Account disabled"
+// @Failure 400 {string} string "Cannot disable your own account"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/users/{username}/disable [post]
+func (h *Handlers) DisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, true)
+}
+
+// EnableUser godoc
+// @Summary Enable an account
+// @Description Lifts a previous disable. Admins only.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param username path string true "Username"
+// @Success 200 {object} map[string]string "Account enabled"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/users/{username}/enable [post]
+func (h *Handlers) EnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, false)
+}
+
+func (h *Handlers) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	username := chi.URLParam(r, "username")
+	if disabled && username == principal.Username {
+		http.Error(w, "cannot disable your own account", http.StatusBadRequest)
+		return
+	}
+	found, err := h.store.SetUserDisabled(principal.Username, username, disabled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]string{"result": "ok"})
+}
+
+// SetUserRole godoc
+// @Summary Change a user's role
+// @Description Sets the role of a user. Access tokens already issued keep the old role until they expire. Admins only, and not for their own account.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param username path string true "Username"
+// @Param request body types.RoleRequest true "New role"
+// @Success 200 {object} map[string]string "Role changed"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/users/{username}/role [put]
+func (h *Handlers) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	username := chi.URLParam(r, "username")
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.RoleRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(types.Roles, request.Role) {
+		http.Error(w, "role must be user, admin or support", http.StatusBadRequest)
+		return
+	}
+	if username == principal.Username {
+		http.Error(w, "cannot change your own role", http.StatusBadRequest)
+		return
+	}
+	found, err := h.store.SetUserRole(principal.Username, username, request.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]string{"result": "ok"})
+}
+
+// AdminTask godoc
+// @Summary View any task
+// @Description Returns a task of any user with its source, options, transcript and worker progress. Available to admins and support.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Task ID"
+// @Success 200 {object} types.AdminTask "Task"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Task not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/tasks/{id} [get]
+func (h *Handlers) AdminTask(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	task, err := h.store.GetAdminTask(principal.Username, chi.URLParam(r, "id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, task)
+}
+
+// AdminRequeueTask godoc
+// @Summary Requeue any task
+// @Description Puts a stuck or failed task of any user back on the queue. Queued tasks get another queue message, active ones go back to queued once their worker has stopped sending heartbeats for the reaper timeout, and failed ones are retried with their original options. Available to admins and support.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Task ID"
+// @Success 200 {object} types.GetStatusResponse "Task requeued"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 409 {string} string "Task not found, still being worked on or already finished"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/tasks/{id}/requeue [post]
+func (h *Handlers) AdminRequeueTask(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	taskID := chi.URLParam(r, "id")
+	requeued, err := h.store.RequeueTask(principal.Username, taskID, config.CurrentConfig.Reaper.Timeout, consumer.TaskQueue)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !requeued {
+		http.Error(w, "only queued, stuck or failed tasks can be requeued", http.StatusConflict)
+		return
+	}
+	if err := consumer.PublishTaskEvent(h.store, h.pubsub, taskID); err != nil {
+		service.LogError("publish event for task %s: %v", taskID, err)
+	}
+	writeJSON(w, types.GetStatusResponse{Status: types.StatusQueued})
+}
+
+// AdminStats godoc
+// @Summary System-wide task statistics
+// @Description Returns task counts by status, activity of the last 24 hours, the queue backlog and user counts. Available to admins and support.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} types.TaskStats "Statistics"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/stats [get]
+func (h *Handlers) AdminStats(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	stats, err := h.store.TaskStats(principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, stats)
+}
+
+// AuditLog godoc
+// @Summary Read the audit log
+// @Description Returns admin and support actions, newest first. Admins only.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param actor query string false "Only actions of this user"
+// @Param limit query int false "Maximum number of entries" default(50)
+// @Param offset query int false "Number of entries to skip" default(0)
+// @Success 200 {object} types.AuditLogResponse "Audit log"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/audit [get]
+func (h *Handlers) AuditLog(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	values := r.URL.Query()
+	limit, offset := adminPage(values)
+	entries, err := h.store.ListAuditLog(principal.Username, values.Get("actor"), limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.AuditLogResponse{Entries: entries})
+}
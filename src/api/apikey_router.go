@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"speechToText/src/auth"
+	"speechToText/src/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxAPIKeyName = 100
+
+func validateAPIKeyRequest(request types.APIKeyRequest) error {
+	if request.Name == "" || len(request.Name) > maxAPIKeyName {
+		return fmt.Errorf("name is required and must be at most %d characters", maxAPIKeyName)
+	}
+	if len(request.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range request.Scopes {
+		if !slices.Contains(types.APIKeyScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// CreateAPIKey godoc
+// @Summary Create an API key
+// @Description Creates a long-lived key for server-to-server use, sent as "Authorization: Bearer <key>". The key is only returned in this response. Keys cannot manage keys.
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Param request body types.APIKeyRequest true "Key name and scopes"
+// @Success 200 {object} types.APIKeyInfo "Key created"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /keys [post]
+func (h *Handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.APIKeyRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateAPIKeyRequest(request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slices.Sort(request.Scopes)
+	secret, prefix, err := auth.NewAPIKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key, err := h.store.CreateAPIKey(principal.Username, request.Name, prefix, auth.HashToken(secret), slices.Compact(request.Scopes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key.Key = secret
+	writeJSON(w, key)
+}
+
+// APIKeys godoc
+// @Summary List API keys
+// @Description Returns the user's API keys, including revoked ones, with when each was last used
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Success 200 {object} types.APIKeyListResponse "Keys list"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /keys [get]
+func (h *Handlers) APIKeys(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	keys, err := h.store.ListAPIKeys(principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.APIKeyListResponse{Keys: keys})
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Revokes a key; requests made with it are rejected from then on
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Param id path string true "Key ID"
+// @Success 200 {object} map[string]string "Key revoked"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Key not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /keys/{id} [delete]
+func (h *Handlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	revoked, err := h.store.RevokeAPIKey(chi.URLParam(r, "id"), principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]string{"result": "ok"})
+}
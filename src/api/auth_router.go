@@ -1,14 +1,24 @@
 package api
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"speechToText/src/auth"
+	"speechToText/src/config"
 	"speechToText/src/db"
 	"speechToText/src/service"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 // Register godoc
 // @Summary User registration
-// @Description Creates a new user in the system
+// @Description Creates a new user in the system. Usernames are case-insensitive and stored in lowercase.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -48,14 +58,15 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 
 // Login godoc
 // @Summary User authentication
-// @Description Authenticates user and creates session
+// @Description Authenticates user and creates a session cookie, or with mode "token" returns a short-lived access token and a refresh token
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body types.AuthRequest true "Authentication data"
-// @Success 200 {object} map[string]string "Successful authentication"
+// @Success 200 {object} types.TokenResponse "Successful authentication; a result and session token in session mode"
 // @Failure 400 {string} string "Validation error"
 // @Failure 401 {string} string "Invalid credentials"
+// @Failure 403 {string} string "Account disabled"
 // @Failure 500 {string} string "Internal server error"
 // @Router /login [post]
 func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
@@ -66,7 +77,11 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	exist, err := h.store.CheckAuthData(user.Username, user.Password)
+	if user.Mode != "" && user.Mode != types.LoginSession && user.Mode != types.LoginToken {
+		http.Error(w, "mode must be session or token", http.StatusBadRequest)
+		return
+	}
+	username, exist, err := h.store.CheckAuthData(user.Username, user.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -75,13 +90,33 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
-	ctx := r.Context()
-	session, err := h.session.SessionStart(ctx, w, r)
+	access, err := h.store.GetUserAccess(username)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if err := session.Set(ctx, "username", user.Username); err != nil {
+	if access.Disabled {
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+	if user.Mode == types.LoginToken {
+		refreshToken, err := auth.NewRefreshToken()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		familyID, err := h.store.CreateRefreshToken(username, auth.HashToken(refreshToken), config.CurrentConfig.Token.RefreshTTL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.writeTokens(w, username, access.Role, familyID, refreshToken)
+		return
+	}
+	session, err := h.session.SessionCreate(r.Context(), w, r, username, auth.ClientIP(r, h.proxies), map[string]string{
+		auth.SessionVersionKey: access.SessionVersion,
+	})
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -91,9 +126,88 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// writeTokens responds with a new access token for the refresh token family
+// and the refresh token.
+func (h *Handlers) writeTokens(w http.ResponseWriter, username string, role string, familyID string, refreshToken string) {
+	cfg := config.CurrentConfig.Token
+	now := time.Now()
+	accessToken, err := h.tokens.Sign(auth.Claims{
+		Subject:   username,
+		ID:        uuid.New().String(),
+		Family:    familyID,
+		Role:      role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(cfg.AccessTTL).Unix(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.TokenResponse{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(cfg.AccessTTL.Seconds()),
+		RefreshToken:     refreshToken,
+		RefreshExpiresIn: int64(cfg.RefreshTTL.Seconds()),
+	})
+}
+
+// RefreshToken godoc
+// @Summary Refresh an access token
+// @Description Exchanges a refresh token for a new access token and a new refresh token. Each refresh token works once; presenting a used one again revokes every token of that login.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body types.RefreshRequest true "Refresh token"
+// @Success 200 {object} types.TokenResponse "New tokens"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Invalid, expired or reused refresh token, or the account is disabled"
+// @Failure 500 {string} string "Internal server error"
+// @Router /token/refresh [post]
+func (h *Handlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.RefreshRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+	refreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	username, role, familyID, err := h.store.RotateRefreshToken(
+		auth.HashToken(request.RefreshToken), auth.HashToken(refreshToken), config.CurrentConfig.Token.RefreshTTL,
+	)
+	if errors.Is(err, db.ErrTokenReused) {
+		service.LogInfo("refresh token reused, revoked its family")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, username, role, familyID, refreshToken)
+}
+
 // Logout godoc
 // @Summary Logout
-// @Description Invalidates the user session
+// @Description Invalidates the user session, or for token logins revokes the refresh token. Access tokens stay valid until they expire.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -103,12 +217,18 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Internal server error"
 // @Router /logout [post]
 func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
-	session, err := h.session.SessionGet(r.Context(), r)
-	if err != nil {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok || principal.SessionID == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	if err := h.session.SessionDestroy(r.Context(), w, session.SessionId); err != nil {
+	var err error
+	if principal.Method == auth.MethodToken {
+		err = h.store.RevokeRefreshFamily(principal.SessionID)
+	} else {
+		err = h.session.SessionDestroy(r.Context(), w, principal.SessionID)
+	}
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
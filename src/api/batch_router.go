@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"speechToText/src/auth"
+	"speechToText/src/consumer"
+	"speechToText/src/types"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxBatchSize = 500
+	// batchValidationWorkers bounds how many items of a batch are checked,
+	// and their hosts resolved, at the same time.
+	batchValidationWorkers = 16
+	// batchValidationTimeout caps the checks of a whole batch, well within
+	// the server's write timeout.
+	batchValidationTimeout = 10 * time.Second
+)
+
+// validateBatch checks every item merged with the batch defaults,
+// concurrently, and returns the error of the first invalid item.
+func (h *Handlers) validateBatch(ctx context.Context, request types.BatchRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, batchValidationTimeout)
+	defer cancel()
+
+	errs := make([]error, len(request.Items))
+	slots := make(chan struct{}, batchValidationWorkers)
+	var wg sync.WaitGroup
+	for i, item := range request.Items {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[i] = h.validateAudioRequest(ctx, consumer.MergeAudioRequest(item, request.Defaults))
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// AudioBatch godoc
+// @Summary Submit a batch of audio sources
+// @Description Creates one task per item in a single transaction. Options missing on an item are taken from defaults.
+// @Tags audio
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Idempotency-Key header string false "Makes retries return the original batch instead of creating a new one"
+// @Param request body types.BatchRequest true "Batch items and shared options"
+// @Success 200 {object} types.BatchResponse "Batch created"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "Request with the same Idempotency-Key in progress"
+// @Failure 422 {string} string "Idempotency-Key reused with a different body"
+// @Failure 500 {string} string "Internal server error"
+// @Router /audio/batch [post]
+func (h *Handlers) AudioBatch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.BatchRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.Items) == 0 {
+		http.Error(w, "items are required", http.StatusBadRequest)
+		return
+	}
+	if len(request.Items) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("a batch can contain at most %d items", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+	if err = h.validateBatch(r.Context(), request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	batchID, taskIDs, err := consumer.CreateBatch(h.store, principal.Username, request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.BatchResponse{BatchID: batchID, TaskIDs: taskIDs})
+}
+
+// Batch godoc
+// @Summary Get batch progress
+// @Description Returns per-status counts and the tasks of a batch
+// @Tags audio
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Batch ID"
+// @Success 200 {object} types.BatchStatusResponse "Batch progress"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Batch not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /batches/{id} [get]
+func (h *Handlers) Batch(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	batch, err := h.store.GetBatch(chi.URLParam(r, "id"), principal.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "batch not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, batch)
+}
+
+// BatchExport godoc
+// @Summary Export batch results
+// @Description Returns the transcripts and errors of every task once the whole batch is finished
+// @Tags audio
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Batch ID"
+// @Success 200 {object} types.BatchExportResponse "Batch results"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Batch not found"
+// @Failure 409 {string} string "Batch still in progress"
+// @Failure 500 {string} string "Internal server error"
+// @Router /batches/{id}/export [get]
+func (h *Handlers) BatchExport(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	batchID := chi.URLParam(r, "id")
+	items, err := h.store.ExportBatch(batchID, principal.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "batch not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, item := range items {
+		if !item.Status.IsFinal() {
+			http.Error(w, "batch still in progress", http.StatusConflict)
+			return
+		}
+	}
+	writeJSON(w, types.BatchExportResponse{BatchID: batchID, Items: items})
+}
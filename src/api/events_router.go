@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"speechToText/src/auth"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const sseKeepAlive = 15 * time.Second
+
+// TaskEvents godoc
+// @Summary Stream task status updates
+// @Description Server-Sent Events stream of status changes for all of the user's tasks. Send Last-Event-ID to resume after a reconnect.
+// @Tags tasks
+// @Produce text/event-stream
+// @Security ApiKeyAuth
+// @Param Last-Event-ID header string false "ID of the last event received"
+// @Success 200 {object} types.TaskEvent "Event stream"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /tasks/events [get]
+func (h *Handlers) TaskEvents(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.streamTaskEvents(w, r, principal.Username, "")
+}
+
+// TaskEventsByID godoc
+// @Summary Stream status updates of one task
+// @Description Server-Sent Events stream for a single task. The current status is sent first and the stream ends once the task is finished.
+// @Tags tasks
+// @Produce text/event-stream
+// @Security ApiKeyAuth
+// @Param id path string true "Task ID"
+// @Param Last-Event-ID header string false "ID of the last event received"
+// @Success 200 {object} types.TaskEvent "Event stream"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /tasks/{id}/events [get]
+func (h *Handlers) TaskEventsByID(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	taskID := chi.URLParam(r, "id")
+	if taskID == "" {
+		http.Error(w, "task id is required", http.StatusBadRequest)
+		return
+	}
+	exist, err := h.store.ExistTask(taskID, principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exist {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	h.streamTaskEvents(w, r, principal.Username, taskID)
+}
+
+// streamTaskEvents writes the user's task events as SSE until the client
+// goes away. A non-empty taskID restricts the stream to that task.
+func (h *Handlers) streamTaskEvents(w http.ResponseWriter, r *http.Request, username string, taskID string) {
+	ctx := r.Context()
+	lastEventID := r.Header.Get("Last-Event-ID")
+	events, err := h.pubsub.TaskEvents(ctx, username, lastEventID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// The server's WriteTimeout would otherwise cut long-lived streams.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(event types.TaskEvent) bool {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return false
+		}
+		if event.ID != "" {
+			if _, err := fmt.Fprintf(w, "id: %s\n", event.ID); err != nil {
+				return false
+			}
+		}
+		if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if taskID != "" && lastEventID == "" {
+		current, err := h.store.GetTaskEvent(taskID)
+		if err != nil {
+			service.LogError("task %s snapshot: %v", taskID, err)
+			return
+		}
+		if !write(current) || current.Status.IsFinal() {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if taskID != "" && event.TaskID != taskID {
+				continue
+			}
+			if !write(event) {
+				return
+			}
+			if taskID != "" && event.Status.IsFinal() {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"speechToText/src/auth"
+	"speechToText/src/config"
+	"speechToText/src/export"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var exportContentTypes = map[string]string{
+	types.ExportJSONL: "application/x-ndjson",
+	types.ExportCSV:   "text/csv",
+	types.ExportZIP:   "application/zip",
+}
+
+// signExport returns the signature of a download link for the export that
+// is valid until expires.
+func (h *Handlers) signExport(exportID string, expires int64) string {
+	mac := hmac.New(sha256.New, h.linkSecret)
+	mac.Write([]byte(exportID + "." + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validateExportRequest(request *types.ExportRequest) error {
+	if !slices.Contains(types.ExportFormats, request.Format) {
+		return fmt.Errorf("invalid format: must be one of jsonl, csv or zip")
+	}
+	if request.Format != types.ExportZIP {
+		if len(request.Files) > 0 {
+			return fmt.Errorf("files are only supported for zip exports")
+		}
+	} else if len(request.Files) == 0 {
+		request.Files = types.ExportFileTypes
+	}
+	for _, fileType := range request.Files {
+		if !slices.Contains(types.ExportFileTypes, fileType) {
+			return fmt.Errorf("invalid file type %q", fileType)
+		}
+	}
+	_, err := request.Filter.TaskFilter()
+	return err
+}
+
+// CreateExport godoc
+// @Summary Export transcripts
+// @Description Starts an asynchronous export of the user's completed transcripts matching the filter, as JSONL, CSV or a ZIP of TXT/SRT/VTT/JSON files per task. Poll GET /exports/{id} for the download link.
+// @Tags exports
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Idempotency-Key header string false "Key that makes retries of this request safe"
+// @Param request body types.ExportRequest true "Format and filter"
+// @Success 202 {object} types.ExportInfo "Export queued"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /exports [post]
+func (h *Handlers) CreateExport(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.ExportRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateExportRequest(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exportID := uuid.New().String()
+	if err = h.store.CreateExport(exportID, principal.Username, request); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info, err := h.store.GetExport(exportID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, info)
+}
+
+// Export godoc
+// @Summary Get export status
+// @Description Returns the progress of an export. Once completed it includes a download link that expires after a while; request the status again for a fresh one.
+// @Tags exports
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Export ID"
+// @Success 200 {object} types.ExportInfo "Export"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Export not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /exports/{id} [get]
+func (h *Handlers) Export(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	info, err := h.store.GetExport(chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "export not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if info.Username != principal.Username {
+		http.Error(w, "export not found", http.StatusNotFound)
+		return
+	}
+	if info.Status == types.ExportCompleted {
+		expires := time.Now().Add(config.CurrentConfig.Export.LinkTTL).Unix()
+		query := url.Values{}
+		query.Set("expires", strconv.FormatInt(expires, 10))
+		query.Set("signature", h.signExport(info.ExportID, expires))
+		info.DownloadURL = "/exports/" + info.ExportID + "/download?" + query.Encode()
+		info.LinkExpires = time.Unix(expires, 0).UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, info)
+}
+
+// DownloadExport godoc
+// @Summary Download an export
+// @Description Streams a completed export. The link from GET /exports/{id} carries its own signature, so no session is needed.
+// @Tags exports
+// @Produce application/x-ndjson,text/csv,application/zip
+// @Param id path string true "Export ID"
+// @Param expires query int true "Link expiry as a Unix timestamp"
+// @Param signature query string true "Link signature"
+// @Success 200 {file} file "Export archive"
+// @Failure 403 {string} string "Invalid or expired link"
+// @Failure 404 {string} string "Export not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /exports/{id}/download [get]
+func (h *Handlers) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	exportID := chi.URLParam(r, "id")
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	signature := r.URL.Query().Get("signature")
+	if err != nil || time.Now().Unix() > expires ||
+		!hmac.Equal([]byte(signature), []byte(h.signExport(exportID, expires))) {
+		http.Error(w, "invalid or expired link", http.StatusForbidden)
+		return
+	}
+	info, err := h.store.GetExport(exportID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "export not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if info.Status != types.ExportCompleted {
+		http.Error(w, "export not found", http.StatusNotFound)
+		return
+	}
+	f, err := h.files.Open(export.Key(info.ExportID, info.Format))
+	if err != nil {
+		service.LogError("open export %s: %v", info.ExportID, err)
+		http.Error(w, "export not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	name := "export-" + info.ExportID + "." + info.Format
+	w.Header().Set("Content-Type", exportContentTypes[info.Format])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, name, time.Time{}, f)
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"speechToText/src/auth"
+	"speechToText/src/cache"
+	"speechToText/src/service"
+	"strconv"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+	maxIdempotencyKey = 255
+)
+
+// responseCapture passes the response through while keeping a copy of it.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// NewIdempotencyMiddleware makes POST handlers safe to retry. A request with
+// an Idempotency-Key header is executed once per user and key; repeats with
+// the same body get the original response, repeats with a different body get
+// 422. Failed requests release the key. It must run after the auth
+// middleware.
+func NewIdempotencyMiddleware(keys *cache.IdempotencyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKey {
+				http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+			ctx := r.Context()
+			principal, ok := auth.FromContext(ctx)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+			fingerprint := hex.EncodeToString(sum[:])
+
+			record, reserved, err := keys.Reserve(ctx, principal.Username, key, fingerprint)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !reserved {
+				switch {
+				case record.Fingerprint != fingerprint:
+					http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+				case record.Status == 0:
+					http.Error(w, "a request with this Idempotency-Key is still in progress", http.StatusConflict)
+				default:
+					if record.ContentType != "" {
+						w.Header().Set("Content-Type", record.ContentType)
+					}
+					w.Header().Set("Idempotent-Replayed", strconv.FormatBool(true))
+					w.WriteHeader(record.Status)
+					if _, err := w.Write(record.Body); err != nil {
+						service.LogError("Write error: %v", err)
+					}
+				}
+				return
+			}
+
+			capture := &responseCapture{ResponseWriter: w}
+			next.ServeHTTP(capture, r)
+
+			// The client may be gone by now, which is when a retry is most
+			// likely, so the outcome must be stored regardless.
+			ctx = context.WithoutCancel(ctx)
+			if capture.status >= 200 && capture.status < 300 {
+				err = keys.Complete(ctx, principal.Username, key, cache.IdempotencyRecord{
+					Fingerprint: fingerprint,
+					Status:      capture.status,
+					ContentType: capture.Header().Get("Content-Type"),
+					Body:        capture.body.Bytes(),
+				})
+			} else {
+				err = keys.Release(ctx, principal.Username, key)
+			}
+			if err != nil {
+				service.LogError("idempotency key %q: %v", key, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"speechToText/src/auth"
+	"speechToText/src/cache"
+	"speechToText/src/config"
+	"speechToText/src/db"
+	appmetrics "speechToText/src/metrics"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"strconv"
+)
+
+// maxAuthRequestSize caps login bodies, which are read before any check.
+const maxAuthRequestSize = 64 << 10
+
+// NewLoginGuard protects the login handler against password guessing. Each
+// failed login (401) delays the next attempt for that username and counts
+// towards lockouts of the username and of the client address; attempts
+// while delayed or locked out, or while another attempt of the username is
+// being checked, get 429 with Retry-After, whatever the password. Lockouts
+// are counted in metrics and written to the audit log.
+func NewLoginGuard(throttle *cache.LoginThrottle, store *db.Store, m *appmetrics.LoginMetrics) func(http.Handler) http.Handler {
+	proxies := auth.ParseTrustedProxies(config.CurrentConfig.Server.TrustedProxies)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAuthRequestSize))
+			r.Body.Close()
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			var request types.AuthRequest
+			if json.Unmarshal(data, &request) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			username := service.NormalizeUsername(request.Username)
+			if username == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			guardAttempt(w, r, next, throttle, store, m, username, auth.ClientIP(r, proxies), http.StatusUnauthorized)
+		})
+	}
+}
+
+// NewPasswordGuard applies the same throttle to handlers that check the
+// logged-in user's password again, such as changing it or deleting the
+// account, so that a stolen session cannot be used to guess it. A wrong
+// password there is answered with 403. It must run after the auth
+// middleware.
+func NewPasswordGuard(throttle *cache.LoginThrottle, store *db.Store, m *appmetrics.LoginMetrics) func(http.Handler) http.Handler {
+	proxies := auth.ParseTrustedProxies(config.CurrentConfig.Server.TrustedProxies)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.FromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			guardAttempt(w, r, next, throttle, store, m, principal.Username, auth.ClientIP(r, proxies), http.StatusForbidden)
+		})
+	}
+}
+
+// guardAttempt runs next as a password attempt of username unless it is
+// delayed, locked out or already has an attempt in progress, and records
+// the outcome: 200 clears the failures of the username, failStatus counts
+// as a failure.
+func guardAttempt(w http.ResponseWriter, r *http.Request, next http.Handler, throttle *cache.LoginThrottle, store *db.Store, m *appmetrics.LoginMetrics, username string, ip string, failStatus int) {
+	// The request context ends when the client half-closes the connection,
+	// but it can still read the answer; the attempt must be counted anyway.
+	ctx := context.WithoutCancel(r.Context())
+	wait, err := throttle.Reserve(ctx, username, ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		m.Throttled.Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+	// Released after the outcome is recorded, so the next attempt sees it.
+	defer func() {
+		if err := throttle.Release(ctx, username); err != nil {
+			service.LogError("login throttle: release %s: %v", username, err)
+		}
+	}()
+
+	capture := &responseCapture{ResponseWriter: w}
+	next.ServeHTTP(capture, r)
+
+	switch capture.status {
+	case http.StatusOK:
+		if err := throttle.Succeed(ctx, username); err != nil {
+			service.LogError("login throttle: reset %s: %v", username, err)
+		}
+	case failStatus:
+		m.Failures.Inc()
+		failure, err := throttle.Fail(ctx, username, ip)
+		if err != nil {
+			service.LogError("login throttle: record failure of %s: %v", username, err)
+			return
+		}
+		if failure.UserLocked {
+			lockedOut(store, m, "username", ip, username, failure.Failures)
+		}
+		if failure.IPLocked {
+			lockedOut(store, m, "ip", ip, ip, failure.IPFailures)
+		}
+	}
+}
+
+// lockedOut records a lockout of target, a username or an address, started
+// by a failed login from ip.
+func lockedOut(store *db.Store, m *appmetrics.LoginMetrics, scope string, ip string, target string, failures int64) {
+	m.Lockouts.WithLabelValues(scope).Inc()
+	service.LogInfo("login: locked out %s %s after %d failures", scope, target, failures)
+	if err := store.AddAuditEntry(ip, types.AuditLoginLocked, target, map[string]any{
+		"scope":    scope,
+		"failures": failures,
+	}); err != nil {
+		service.LogError("login: audit lockout of %s: %v", target, err)
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"speechToText/src/auth"
+	"speechToText/src/config"
+	"speechToText/src/types"
+	"strconv"
+)
+
+const (
+	maxRetentionDays = 3650
+	maxPurgeListSize = 100
+)
+
+func validateRetentionDays(days *int, name string) error {
+	if days != nil && (*days < 0 || *days > maxRetentionDays) {
+		return fmt.Errorf("invalid %s: must be between 0 and %d", name, maxRetentionDays)
+	}
+	return nil
+}
+
+// Retention godoc
+// @Summary Get retention settings
+// @Description Returns how many days the user's audio URLs and transcripts are kept. A null setting uses the service default; 0 keeps data forever.
+// @Tags retention
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} types.RetentionResponse "Retention settings"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /retention [get]
+func (h *Handlers) Retention(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	settings, err := h.store.GetRetention(principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.RetentionResponse{
+		RetentionSettings:     settings,
+		DefaultAudioDays:      config.CurrentConfig.Retention.AudioDays,
+		DefaultTranscriptDays: config.CurrentConfig.Retention.TranscriptDays,
+	})
+}
+
+// UpdateRetention godoc
+// @Summary Update retention settings
+// @Description Sets how many days audio URLs and transcripts are kept, counted from task creation. Existing tasks get new expiry times. Null restores the service default; 0 keeps data forever.
+// @Tags retention
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body types.RetentionSettings true "Retention settings"
+// @Success 200 {object} types.RetentionResponse "Retention settings"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /retention [put]
+func (h *Handlers) UpdateRetention(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var settings types.RetentionSettings
+	if err = json.Unmarshal(data, &settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateRetentionDays(settings.AudioDays, "audio_days"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateRetentionDays(settings.TranscriptDays, "transcript_days"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = h.store.SetRetention(principal.Username, settings); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.RetentionResponse{
+		RetentionSettings:     settings,
+		DefaultAudioDays:      config.CurrentConfig.Retention.AudioDays,
+		DefaultTranscriptDays: config.CurrentConfig.Retention.TranscriptDays,
+	})
+}
+
+// RetentionPurges godoc
+// @Summary List purged data
+// @Description Returns the most recent purges of the user's data: erased audio URLs and deleted tasks
+// @Tags retention
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param limit query int false "Maximum number of entries" default(50)
+// @Success 200 {object} types.PurgeListResponse "Purge log"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /retention/purges [get]
+func (h *Handlers) RetentionPurges(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= maxPurgeListSize {
+			limit = l
+		}
+	}
+	purges, err := h.store.ListPurges(principal.Username, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.PurgeListResponse{Purges: purges})
+}
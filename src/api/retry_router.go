@@ -0,0 +1,206 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"speechToText/src/auth"
+	"speechToText/src/consumer"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultBulkRetryLimit = 100
+	maxBulkRetryLimit     = 1000
+)
+
+func parseFilterTime(value string, name string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: must be an RFC 3339 timestamp", name)
+	}
+	return t.UTC(), nil
+}
+
+// RetryTask godoc
+// @Summary Retry a failed task
+// @Description Re-enqueues a failed task under the same ID with its original source and options. Model and language can be overridden. The previous attempt is kept in the task's history.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Task ID"
+// @Param request body types.RetryRequest false "Option overrides"
+// @Success 200 {object} types.GetStatusResponse "Task requeued"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 409 {string} string "Task has not failed"
+// @Failure 500 {string} string "Internal server error"
+// @Router /tasks/{id}/retry [post]
+func (h *Handlers) RetryTask(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	taskID := chi.URLParam(r, "id")
+	if taskID == "" {
+		http.Error(w, "task id is required", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.RetryRequest
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err = validateOverrides(request.TranscriptionOptions); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exist, err := h.store.ExistTask(taskID, principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exist {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	retried, err := h.store.RetryTask(taskID, principal.Username, request.TranscriptionOptions, consumer.TaskQueue)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !retried {
+		http.Error(w, "only failed tasks can be retried", http.StatusConflict)
+		return
+	}
+	if err := consumer.PublishTaskEvent(h.store, h.pubsub, taskID); err != nil {
+		service.LogError("publish event for task %s: %v", taskID, err)
+	}
+	writeJSON(w, types.GetStatusResponse{Status: types.StatusQueued})
+}
+
+// RetryTasks godoc
+// @Summary Retry failed tasks in bulk
+// @Description Re-enqueues the user's failed tasks matching the filter, oldest first
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body types.BulkRetryRequest true "Filter and option overrides"
+// @Success 200 {object} types.BulkRetryResponse "Requeued tasks"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /tasks/retry [post]
+func (h *Handlers) RetryTasks(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.BulkRetryRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateOverrides(request.TranscriptionOptions); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := types.TaskFilter{TaskIDs: request.TaskIDs, BatchID: request.BatchID, Limit: request.Limit}
+	if filter.CreatedAfter, err = parseFilterTime(request.CreatedAfter, "created_after"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.CreatedBefore, err = parseFilterTime(request.CreatedBefore, "created_before"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultBulkRetryLimit
+	}
+	if filter.Limit > maxBulkRetryLimit {
+		filter.Limit = maxBulkRetryLimit
+	}
+	taskIDs, err := h.store.RetryTasks(principal.Username, filter, request.TranscriptionOptions, consumer.TaskQueue)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, taskID := range taskIDs {
+		if err := consumer.PublishTaskEvent(h.store, h.pubsub, taskID); err != nil {
+			service.LogError("publish event for task %s: %v", taskID, err)
+		}
+	}
+	writeJSON(w, types.BulkRetryResponse{TaskIDs: taskIDs})
+}
+
+// TaskAttempts godoc
+// @Summary Get task attempt history
+// @Description Returns the current attempt number and the status and error of every previous attempt
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Task ID"
+// @Success 200 {object} types.TaskAttemptsResponse "Attempt history"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal server error"
+// @Router /tasks/{id}/attempts [get]
+func (h *Handlers) TaskAttempts(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	taskID := chi.URLParam(r, "id")
+	exist, err := h.store.ExistTask(taskID, principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exist {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	current, attempts, err := h.store.GetTaskAttempts(taskID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.TaskAttemptsResponse{TaskID: taskID, Attempt: current, Attempts: attempts})
+}
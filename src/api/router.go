@@ -1,45 +1,106 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
+	"net/netip"
+	"regexp"
+	"speechToText/src/auth"
 	"speechToText/src/cache"
+	"speechToText/src/config"
 	"speechToText/src/consumer"
 	"speechToText/src/db"
 	"speechToText/src/service"
+	"speechToText/src/storage"
 	"speechToText/src/types"
-	"strconv"
+	"speechToText/src/urlpolicy"
 
 	"github.com/go-chi/chi/v5"
 )
 
 // Handlers holds all service dependencies for HTTP handlers.
 type Handlers struct {
-	store    *db.Store
-	session  *cache.RedisSessionManager
-	producer *consumer.Producer
+	store      *db.Store
+	session    *cache.RedisSessionManager
+	pubsub     *cache.PubSub
+	files      storage.Storage
+	linkSecret []byte
+	sources    *urlpolicy.Policy
+	callbacks  *urlpolicy.Policy
+	tokens     *auth.TokenSigner
+	proxies    []netip.Prefix
 }
 
-func NewHandlers(store *db.Store, session *cache.RedisSessionManager, producer *consumer.Producer) *Handlers {
-	return &Handlers{store: store, session: session, producer: producer}
+func NewHandlers(store *db.Store, session *cache.RedisSessionManager, pubsub *cache.PubSub, files storage.Storage, tokens *auth.TokenSigner) *Handlers {
+	linkSecret := []byte(config.CurrentConfig.Export.LinkSecret)
+	if len(linkSecret) == 0 {
+		// Only reached in all mode, where links then only work on this
+		// instance until it restarts.
+		service.LogInfo("EXPORT_LINK_SECRET is not set, using a random key for download links")
+		linkSecret = make([]byte, 32)
+		_, _ = rand.Read(linkSecret)
+	}
+	return &Handlers{
+		store:      store,
+		session:    session,
+		pubsub:     pubsub,
+		files:      files,
+		linkSecret: linkSecret,
+		sources:    urlpolicy.New(config.CurrentConfig.SourceURL),
+		callbacks:  urlpolicy.New(config.CurrentConfig.CallbackURL),
+		tokens:     tokens,
+		proxies:    auth.ParseTrustedProxies(config.CurrentConfig.Server.TrustedProxies),
+	}
 }
 
-func validateAudioURL(audioURL string) error {
+// validateAudioURL checks that the source URL is allowed by the source URL
+// policy, which resolves its host.
+func (h *Handlers) validateAudioURL(ctx context.Context, audioURL string) error {
 	if audioURL == "" {
 		return fmt.Errorf("audio URL is required")
 	}
-	u, err := url.Parse(audioURL)
-	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
-		return fmt.Errorf("invalid audio URL: must be an http or https URL")
+	if err := h.sources.Check(ctx, audioURL); err != nil {
+		return fmt.Errorf("invalid audio URL: %w", err)
+	}
+	return nil
+}
+
+var optionPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// validateOverrides checks the transcription options passed to the provider.
+func validateOverrides(options types.TranscriptionOptions) error {
+	if options.Model != "" && !optionPattern.MatchString(options.Model) {
+		return fmt.Errorf("invalid model")
+	}
+	if options.Language != "" && !optionPattern.MatchString(options.Language) {
+		return fmt.Errorf("invalid language")
 	}
 	return nil
 }
 
+// validateAudioRequest checks the source URL, callback URL, tags and options
+// of a single submission.
+func (h *Handlers) validateAudioRequest(ctx context.Context, request types.AudioRequest) error {
+	if err := h.validateAudioURL(ctx, request.Audio); err != nil {
+		return err
+	}
+	if request.CallbackURL != "" {
+		if err := h.validateCallbackURL(ctx, request.CallbackURL); err != nil {
+			return err
+		}
+	}
+	if err := validateTags(request.Tags); err != nil {
+		return err
+	}
+	return validateOverrides(request.TranscriptionOptions)
+}
+
 func writeJSON(w http.ResponseWriter, v any) {
 	response, err := json.Marshal(v)
 	if err != nil {
@@ -59,22 +120,20 @@ func writeJSON(w http.ResponseWriter, v any) {
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
-// @Param request body types.AudioRequest true "Audio URL"
+// @Param Idempotency-Key header string false "Makes retries return the original task instead of creating a new one"
+// @Param request body types.AudioRequest true "Audio URL, optional callback_url for task events and transcription options"
 // @Success 200 {object} types.GetInfoResponse "Task ID created"
 // @Failure 400 {string} string "Validation error"
 // @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "Request with the same Idempotency-Key in progress"
+// @Failure 422 {string} string "Idempotency-Key reused with a different body"
 // @Failure 500 {string} string "Internal server error"
 // @Router /audio [post]
 func (h *Handlers) Audio(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	session, err := h.session.SessionGet(r.Context(), r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	username, err := session.Get(r.Context(), "username")
-	if err != nil || username == "" {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -88,11 +147,11 @@ func (h *Handlers) Audio(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err = validateAudioURL(request.Audio); err != nil {
+	if err = h.validateAudioRequest(r.Context(), request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	taskID, err := consumer.CreateTask(h.store, h.producer, username, request)
+	taskID, err := consumer.CreateTask(h.store, principal.Username, request)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -115,13 +174,8 @@ func (h *Handlers) Audio(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Internal server error"
 // @Router /status [get]
 func (h *Handlers) Status(w http.ResponseWriter, r *http.Request) {
-	session, err := h.session.SessionGet(r.Context(), r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	username, err := session.Get(r.Context(), "username")
-	if err != nil || username == "" {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -130,7 +184,7 @@ func (h *Handlers) Status(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "task_id is required", http.StatusBadRequest)
 		return
 	}
-	exist, err := h.store.ExistTask(taskID, username)
+	exist, err := h.store.ExistTask(taskID, principal.Username)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -148,7 +202,7 @@ func (h *Handlers) Status(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, types.GetStatusResponse{Status: status})
+	writeJSON(w, status)
 }
 
 // Result godoc
@@ -166,13 +220,8 @@ func (h *Handlers) Status(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Internal server error"
 // @Router /result [get]
 func (h *Handlers) Result(w http.ResponseWriter, r *http.Request) {
-	session, err := h.session.SessionGet(r.Context(), r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	username, err := session.Get(r.Context(), "username")
-	if err != nil || username == "" {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -181,7 +230,7 @@ func (h *Handlers) Result(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "task_id is required", http.StatusBadRequest)
 		return
 	}
-	exist, err := h.store.ExistTask(taskID, username)
+	exist, err := h.store.ExistTask(taskID, principal.Username)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -199,64 +248,99 @@ func (h *Handlers) Result(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, types.GetResultResponse{Result: result})
+	writeJSON(w, result)
 }
 
 // Tasks godoc
-// @Summary Get tasks list with pagination
-// @Description Returns user tasks list with pagination
+// @Summary List tasks
+// @Description Returns the user's tasks, filtered and sorted. Pass cursor or limit for cursor pagination and follow next_cursor; otherwise page and page_size select a numbered page and the response includes totals.
 // @Tags tasks
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
+// @Param status query string false "Comma-separated statuses"
+// @Param created_after query string false "Only tasks created at or after this RFC 3339 time"
+// @Param created_before query string false "Only tasks created before this RFC 3339 time"
+// @Param language query string false "Transcription language"
+// @Param tag query string false "Tag the task was submitted with"
+// @Param text_prefix query string false "Case-insensitive prefix of the transcript"
+// @Param sort query string false "created_at, status or language; prefix with - for descending" default(-created_at)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "Page size for cursor pagination" default(10)
 // @Param page query int false "Page number" default(1)
 // @Param page_size query int false "Page size" default(10)
 // @Success 200 {object} types.TaskListResponse "Tasks list"
+// @Failure 400 {string} string "Invalid filter, sort or cursor"
 // @Failure 401 {string} string "Unauthorized"
 // @Failure 500 {string} string "Internal server error"
 // @Router /tasks [get]
 func (h *Handlers) Tasks(w http.ResponseWriter, r *http.Request) {
-	session, err := h.session.SessionGet(r.Context(), r)
-	if err != nil {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	username, err := session.Get(r.Context(), "username")
-	if err != nil || username == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+	values := r.URL.Query()
+	var query types.TaskQuery
+	var err error
+	if query.Filter, err = parseTaskFilter(values); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if query.Sort, query.Desc, err = parseTaskSort(values.Get("sort")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort := query.Sort
+	if query.Desc {
+		sort = "-" + sort
+	}
 
-	page := 1
-	pageSize := 10
-
-	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
+	// Page-numbered requests keep the old response with totals; cursor
+	// pagination skips the count.
+	cursorMode := values.Has("cursor") || values.Has("limit")
+	var page, pageSize int
+	if cursorMode {
+		if query.Filter.Limit, err = parseLimit(values.Get("limit")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-	}
-	if pageSizeStr := r.URL.Query().Get("page_size"); pageSizeStr != "" {
-		if ps, err := strconv.Atoi(pageSizeStr); err == nil && ps > 0 && ps <= 100 {
-			pageSize = ps
+		if value := values.Get("cursor"); value != "" {
+			if query.After, err = decodeCursor(sort, value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 		}
+	} else {
+		page, pageSize = parsePageParams(values)
+		query.Filter.Limit = pageSize
+		query.Offset = (page - 1) * pageSize
 	}
 
-	tasks, total, err := h.store.GetTasksWithPagination(username, page, pageSize)
+	tasks, next, err := h.store.ListTasks(principal.Username, query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
-	writeJSON(w, types.TaskListResponse{
-		Tasks: tasks,
-		Pagination: types.PaginationResponse{
+	response := types.TaskListResponse{Tasks: tasks}
+	if next != nil {
+		response.NextCursor = encodeCursor(sort, *next)
+	}
+	if !cursorMode {
+		total, err := h.store.CountTasks(principal.Username, query.Filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Pagination = &types.PaginationResponse{
 			Page:       page,
 			PageSize:   pageSize,
 			Total:      total,
-			TotalPages: totalPages,
-		},
-	})
+			TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		}
+	}
+	writeJSON(w, response)
 }
 
 // DeleteTask godoc
@@ -273,13 +357,52 @@ func (h *Handlers) Tasks(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Internal server error"
 // @Router /tasks/{id} [delete]
 func (h *Handlers) DeleteTask(w http.ResponseWriter, r *http.Request) {
-	session, err := h.session.SessionGet(r.Context(), r)
-	if err != nil {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	username, err := session.Get(r.Context(), "username")
-	if err != nil || username == "" {
+	taskID := chi.URLParam(r, "id")
+	if taskID == "" {
+		http.Error(w, "task id is required", http.StatusBadRequest)
+		return
+	}
+	exist, err := h.store.ExistTask(taskID, principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exist {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err := h.store.DeleteTask(taskID, principal.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.pubsub.PublishCancel(r.Context(), taskID); err != nil {
+		service.LogError("publish cancel for task %s: %v", taskID, err)
+	}
+	writeJSON(w, map[string]string{"result": "ok"})
+}
+
+// CancelTask godoc
+// @Summary Cancel a task
+// @Description Stops a task that is queued or being processed; only the owner can cancel
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Task ID"
+// @Success 200 {object} types.GetStatusResponse "Task cancelled"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 409 {string} string "Task already finished"
+// @Failure 500 {string} string "Internal server error"
+// @Router /tasks/{id}/cancel [post]
+func (h *Handlers) CancelTask(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -288,7 +411,7 @@ func (h *Handlers) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "task id is required", http.StatusBadRequest)
 		return
 	}
-	exist, err := h.store.ExistTask(taskID, username)
+	exist, err := h.store.ExistTask(taskID, principal.Username)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -297,9 +420,20 @@ func (h *Handlers) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
-	if err := h.store.DeleteTask(taskID, username); err != nil {
+	cancelled, err := h.store.CancelTask(taskID, principal.Username)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, map[string]string{"result": "ok"})
+	if !cancelled {
+		http.Error(w, "task already finished", http.StatusConflict)
+		return
+	}
+	if err := h.pubsub.PublishCancel(r.Context(), taskID); err != nil {
+		service.LogError("publish cancel for task %s: %v", taskID, err)
+	}
+	if err := consumer.PublishTaskEvent(h.store, h.pubsub, taskID); err != nil {
+		service.LogError("publish event for task %s: %v", taskID, err)
+	}
+	writeJSON(w, types.GetStatusResponse{Status: types.StatusCancelled})
 }
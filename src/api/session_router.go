@@ -0,0 +1,129 @@
+package api
+
+import (
+	"net/http"
+	"speechToText/src/auth"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Sessions godoc
+// @Summary List sessions
+// @Description Returns the user's active login sessions, most recently used first, with the device, address and user agent they were last used from
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} types.SessionListResponse "Sessions"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /sessions [get]
+func (h *Handlers) Sessions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessions, err := h.session.UserSessions(r.Context(), principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := types.SessionListResponse{Sessions: []types.SessionInfo{}}
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, types.SessionInfo{
+			ID:         session.Handle(),
+			Current:    principal.Method == auth.MethodSession && session.SessionId == principal.SessionID,
+			Device:     service.DeviceName(session.UserAgent),
+			IP:         session.IP,
+			UserAgent:  session.UserAgent,
+			Created:    session.CreatedAt.Format(time.RFC3339),
+			LastSeenAt: session.LastSeenAt.Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, response)
+}
+
+// RevokeSession godoc
+// @Summary Revoke a session
+// @Description Logs out one of the user's sessions, identified by the id from the session list. Revoking the current session logs it out as well.
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Session ID from the session list"
+// @Success 200 {object} map[string]string "Session revoked"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Session not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /sessions/{id} [delete]
+func (h *Handlers) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+	sessions, err := h.session.UserSessions(ctx, principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handle := chi.URLParam(r, "id")
+	for _, session := range sessions {
+		if session.Handle() != handle {
+			continue
+		}
+		if principal.Method == auth.MethodSession && session.SessionId == principal.SessionID {
+			err = h.session.SessionDestroy(ctx, w, session.SessionId)
+		} else {
+			err = h.session.RevokeSession(ctx, session.SessionId)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]string{"result": "ok"})
+		return
+	}
+	http.Error(w, "Session not found", http.StatusNotFound)
+}
+
+// RevokeAllSessions godoc
+// @Summary Log out everywhere
+// @Description Logs out every session of the user, including the current one, and revokes all refresh tokens. Access tokens stay valid until they expire.
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} types.RevokeSessionsResponse "Sessions revoked"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /sessions [delete]
+func (h *Handlers) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+	if err := h.store.RevokeUserRefreshTokens(principal.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	revoked, err := h.session.RevokeSessions(ctx, principal.Username, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if principal.Method == auth.MethodSession {
+		if err := h.session.SessionDestroy(ctx, w, principal.SessionID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, types.RevokeSessionsResponse{Revoked: revoked})
+}
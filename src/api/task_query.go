@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"speechToText/src/types"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultTaskPageSize = 10
+	maxTaskPageSize     = 100
+	maxTags             = 10
+)
+
+// validateTags checks the tags of a submission.
+func validateTags(tags []string) error {
+	if len(tags) > maxTags {
+		return fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	for _, tag := range tags {
+		if !optionPattern.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+	return nil
+}
+
+// parseTaskSort parses a sort parameter such as "status" or "-created_at",
+// where a leading "-" means descending. The default is newest first.
+func parseTaskSort(value string) (string, bool, error) {
+	if value == "" {
+		return types.SortCreatedAt, true, nil
+	}
+	field, desc := strings.CutPrefix(value, "-")
+	if !slices.Contains(types.TaskSortFields, field) {
+		return "", false, fmt.Errorf("invalid sort: must be one of %s, optionally prefixed with -", strings.Join(types.TaskSortFields, ", "))
+	}
+	return field, desc, nil
+}
+
+// encodeCursor makes the opaque next_cursor for a listing sorted by sort.
+func encodeCursor(sort string, cursor types.TaskCursor) string {
+	cursor.Sort = sort
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(sort string, value string) (*types.TaskCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor types.TaskCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.TaskID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if cursor.Sort != sort {
+		return nil, fmt.Errorf("cursor was issued for a different sort")
+	}
+	return &cursor, nil
+}
+
+// parseTaskFilter reads the listing filters from the query string.
+func parseTaskFilter(values url.Values) (types.TaskFilter, error) {
+	filter := types.TaskFilter{
+		Language:   values.Get("language"),
+		Tag:        values.Get("tag"),
+		TextPrefix: values.Get("text_prefix"),
+	}
+	if status := values.Get("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			status := types.TaskStatus(strings.TrimSpace(s))
+			if !slices.Contains(types.TaskStatuses, status) {
+				return filter, fmt.Errorf("invalid status %q", status)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	var err error
+	if filter.CreatedAfter, err = parseFilterTime(values.Get("created_after"), "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseFilterTime(values.Get("created_before"), "created_before"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parsePageParams reads the legacy page and page_size parameters, falling
+// back to the defaults for missing or invalid values.
+func parsePageParams(values url.Values) (int, int) {
+	page, pageSize := 1, defaultTaskPageSize
+	if p, err := strconv.Atoi(values.Get("page")); err == nil && p > 0 {
+		page = p
+	}
+	if ps, err := strconv.Atoi(values.Get("page_size")); err == nil && ps > 0 && ps <= maxTaskPageSize {
+		pageSize = ps
+	}
+	return page, pageSize
+}
+
+// parseLimit reads the limit parameter of cursor pagination.
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return defaultTaskPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxTaskPageSize {
+		return 0, fmt.Errorf("invalid limit: must be between 1 and %d", maxTaskPageSize)
+	}
+	return limit, nil
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"speechToText/src/auth"
+	"speechToText/src/types"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxDeliveryListSize = 100
+
+// validateCallbackURL checks that a webhook or callback endpoint is allowed
+// by the callback URL policy, which resolves its host.
+func (h *Handlers) validateCallbackURL(ctx context.Context, callbackURL string) error {
+	if err := h.callbacks.Check(ctx, callbackURL); err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	}
+	return nil
+}
+
+// CreateWebhook godoc
+// @Summary Register a webhook
+// @Description Registers an endpoint that receives task events for all of the user's tasks. The returned secret signs every delivery.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body types.WebhookRequest true "Webhook endpoint"
+// @Success 200 {object} types.WebhookInfo "Webhook created"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /webhooks [post]
+func (h *Handlers) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request types.WebhookRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = h.validateCallbackURL(r.Context(), request.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.Events) == 0 {
+		request.Events = types.WebhookEvents
+	}
+	for _, event := range request.Events {
+		if !slices.Contains(types.WebhookEvents, event) {
+			http.Error(w, fmt.Sprintf("unknown event %q", event), http.StatusBadRequest)
+			return
+		}
+	}
+	webhook, err := h.store.CreateWebhook(principal.Username, request.URL, request.Events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, webhook)
+}
+
+// Webhooks godoc
+// @Summary List webhooks
+// @Description Returns the user's webhooks. Signing secrets are only returned when a webhook is created; the secret for per-task callback_url deliveries is created at registration and can be replaced through POST /webhooks/callback-secret
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} types.WebhookListResponse "Webhooks list"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /webhooks [get]
+func (h *Handlers) Webhooks(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	webhooks, err := h.store.ListWebhooks(principal.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.WebhookListResponse{Webhooks: webhooks})
+}
+
+// RotateCallbackSecret godoc
+// @Summary Replace the callback secret
+// @Description Replaces the secret that signs per-task callback_url deliveries and returns it. It is not shown again; deliveries already queued are signed with the previous secret.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} types.CallbackSecretResponse "New secret"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /webhooks/callback-secret [post]
+func (h *Handlers) RotateCallbackSecret(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	secret, err := h.store.RotateCallbackSecret(principal.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.CallbackSecretResponse{Secret: secret})
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook
+// @Description Removes a webhook endpoint; pending deliveries to it are still attempted
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Webhook ID"
+// @Success 200 {object} map[string]string "Webhook deleted"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Webhook not found"
+// @Router /webhooks/{id} [delete]
+func (h *Handlers) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := h.store.DeleteWebhook(chi.URLParam(r, "id"), principal.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]string{"result": "ok"})
+}
+
+// WebhookDeliveries godoc
+// @Summary Webhook delivery log
+// @Description Returns the user's most recent webhook deliveries, newest first
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param task_id query string false "Only deliveries for this task"
+// @Param limit query int false "Maximum number of deliveries" default(50)
+// @Success 200 {object} types.WebhookDeliveryListResponse "Delivery log"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /webhooks/deliveries [get]
+func (h *Handlers) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= maxDeliveryListSize {
+			limit = l
+		}
+	}
+	deliveries, err := h.store.ListWebhookDeliveries(principal.Username, r.URL.Query().Get("task_id"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, types.WebhookDeliveryListResponse{Deliveries: deliveries})
+}
+
+// RedeliverWebhook godoc
+// @Summary Redeliver a webhook event
+// @Description Queues a new delivery with the same payload as a past one
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Delivery ID"
+// @Success 200 {object} map[string]int64 "New delivery ID"
+// @Failure 400 {string} string "Validation error"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Delivery not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /webhooks/deliveries/{id}/redeliver [post]
+func (h *Handlers) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid delivery id", http.StatusBadRequest)
+		return
+	}
+	newID, err := h.store.RedeliverWebhook(id, principal.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "delivery not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int64{"delivery_id": newID})
+}
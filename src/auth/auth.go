@@ -1,23 +1,218 @@
 package auth
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
+	"slices"
 	"speechToText/src/cache"
+	"speechToText/src/config"
+	"speechToText/src/db"
+	"speechToText/src/service"
+	"strings"
+	"time"
 )
 
-func NewMiddleware(session *cache.RedisSessionManager) func(http.Handler) http.Handler {
+// Ways a principal can authenticate.
+const (
+	MethodSession = "session"
+	MethodToken   = "token"
+	MethodAPIKey  = "api_key"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Username string
+	Method   string
+	Role     string
+	// Scopes limits what an API key may do; nil grants every scope.
+	Scopes []string
+	// SessionID is the session cookie or, for access tokens, the refresh
+	// token family.
+	SessionID string
+	KeyID     string
+}
+
+// HasScope reports whether the principal may use routes requiring scope.
+func (p Principal) HasScope(scope string) bool {
+	return p.Scopes == nil || slices.Contains(p.Scopes, scope)
+}
+
+// sessionTouchInterval is how often a session's last-seen time is written.
+const sessionTouchInterval = time.Minute
+
+// SessionVersionKey is the session value holding the user's session
+// version at login. Changing the password starts a new version, which ends
+// every session holding an older one.
+const SessionVersionKey = "session_version"
+
+type principalContextKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal.
+func NewContext(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// FromContext returns the principal stored by the middleware, if any.
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(Principal)
+	return principal, ok
+}
+
+// NewMiddleware authenticates requests with an API key or access token in an
+// "Authorization: Bearer" header, or with a session cookie, and stores the
+// principal in the request context. Every request is checked against the
+// account as it is now: disabled accounts are refused, roles are taken from
+// the database rather than from a token, and sessions and access tokens
+// from before a password change or logout stop working.
+func NewMiddleware(session *cache.RedisSessionManager, store *db.Store, tokens *TokenSigner) func(http.Handler) http.Handler {
+	proxies := ParseTrustedProxies(config.CurrentConfig.Server.TrustedProxies)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			sess, err := session.SessionGet(r.Context(), r)
+			ctx := r.Context()
+			token, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if hasToken && !strings.HasPrefix(token, KeyPrefix) {
+				claims, err := tokens.Verify(token, time.Now())
+				if err != nil {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				access, active, err := store.GetTokenAccess(claims.Subject, claims.Family)
+				if errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if !active {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				if access.Disabled {
+					http.Error(w, "Account disabled", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(NewContext(ctx, Principal{
+					Username:  claims.Subject,
+					Method:    MethodToken,
+					Role:      access.Role,
+					SessionID: claims.Family,
+				})))
+				return
+			}
+			if hasToken {
+				key, role, err := store.UseAPIKey(HashToken(token))
+				if errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(NewContext(ctx, Principal{
+					Username: key.Username,
+					Method:   MethodAPIKey,
+					Role:     role,
+					Scopes:   append([]string{}, key.Scopes...),
+					KeyID:    key.ID,
+				})))
+				return
+			}
+
+			sess, err := session.SessionGet(ctx, r)
 			if err != nil {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
-			username, err := sess.Get(r.Context(), "username")
-			if err != nil || username == "" {
+			username := sess.Username
+			if username == "" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			access, err := store.GetUserAccess(username)
+			if errors.Is(err, sql.ErrNoRows) {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if sess.Values[SessionVersionKey] != access.SessionVersion {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if access.Disabled {
+				http.Error(w, "Account disabled", http.StatusForbidden)
+				return
+			}
+			if ip := ClientIP(r, proxies); ip != sess.IP || time.Since(sess.LastSeenAt) >= sessionTouchInterval {
+				if err := session.Touch(ctx, sess, ip); err != nil {
+					service.LogError("touch session: %v", err)
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(ctx, Principal{
+				Username:  username,
+				Method:    MethodSession,
+				Role:      access.Role,
+				SessionID: sess.SessionId,
+			})))
+		})
+	}
+}
+
+// RequireScope rejects principals without scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := FromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !principal.HasScope(scope) {
+				http.Error(w, "API key lacks scope "+scope, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireLogin rejects API keys, for routes such as key management that only
+// a user who logged in with a password may reach.
+func RequireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := FromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if principal.Method == MethodAPIKey {
+			http.Error(w, "Not available to API keys", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole rejects principals without one of the roles.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := FromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !slices.Contains(roles, principal.Role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
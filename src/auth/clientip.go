@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"speechToText/src/service"
+	"strings"
+)
+
+// ParseTrustedProxies reads proxy addresses and CIDR ranges, skipping
+// invalid entries.
+func ParseTrustedProxies(entries []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else {
+			service.LogError("TRUSTED_PROXIES: invalid entry %q", entry)
+		}
+	}
+	return prefixes
+}
+
+func trusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client: the peer address, or if the
+// peer is a trusted proxy, the last X-Forwarded-For entry not added by a
+// trusted proxy.
+func ClientIP(r *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if !trusted(addr, proxies) {
+		return addr.String()
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if addr = hop.Unmap(); !trusted(addr, proxies) {
+			break
+		}
+	}
+	return addr.String()
+}
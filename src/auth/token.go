@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"speechToText/src/config"
+	"speechToText/src/service"
+	"strings"
+	"time"
+)
+
+// Supported token signing algorithms.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, badly signed or
+// expired.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the JWT claims of an access token. Family is the refresh token
+// family the token was issued with, which logout revokes, and Role the
+// user's role when it was issued.
+type Claims struct {
+	Subject   string `json:"sub"`
+	ID        string `json:"jti"`
+	Family    string `json:"sid"`
+	Role      string `json:"role,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+type tokenKey struct {
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// TokenSigner signs and verifies JWT access tokens with one algorithm and a
+// set of keys identified by kid.
+type TokenSigner struct {
+	algorithm string
+	current   string
+	keys      map[string]tokenKey
+}
+
+// NewTokenSigner loads the keys from cfg. Without keys it signs with a
+// random key, so tokens stop working on restart and on other instances.
+func NewTokenSigner(cfg *config.TokenConfig) (*TokenSigner, error) {
+	if cfg.Algorithm != AlgorithmHS256 && cfg.Algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported token algorithm %q", cfg.Algorithm)
+	}
+	signer := &TokenSigner{algorithm: cfg.Algorithm, keys: make(map[string]tokenKey)}
+	entries := cfg.Keys
+	if len(entries) == 0 {
+		service.LogInfo("TOKEN_KEYS is not set, signing tokens with a random key")
+		seed := make([]byte, 32)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		entries = []string{"ephemeral=" + base64.StdEncoding.EncodeToString(seed)}
+	}
+	for i, entry := range entries {
+		kid, value, ok := strings.Cut(entry, "=")
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("token key %d: expected kid=base64key", i)
+		}
+		raw, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("token key %s: %w", kid, err)
+		}
+		var key tokenKey
+		if cfg.Algorithm == AlgorithmEdDSA {
+			if len(raw) != ed25519.SeedSize {
+				return nil, fmt.Errorf("token key %s: Ed25519 seeds are %d bytes", kid, ed25519.SeedSize)
+			}
+			key.private = ed25519.NewKeyFromSeed(raw)
+			key.public = key.private.Public().(ed25519.PublicKey)
+		} else {
+			if len(raw) < 32 {
+				return nil, fmt.Errorf("token key %s: HS256 secrets must be at least 32 bytes", kid)
+			}
+			key.secret = raw
+		}
+		if _, exists := signer.keys[kid]; exists {
+			return nil, fmt.Errorf("token key %s is listed twice", kid)
+		}
+		signer.keys[kid] = key
+		if i == 0 {
+			signer.current = kid
+		}
+	}
+	return signer, nil
+}
+
+func (s *TokenSigner) sign(key tokenKey, input string) []byte {
+	if s.algorithm == AlgorithmEdDSA {
+		return ed25519.Sign(key.private, []byte(input))
+	}
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
+}
+
+// Sign returns claims as a compact JWT signed with the current key.
+func (s *TokenSigner) Sign(claims Claims) (string, error) {
+	header, err := json.Marshal(tokenHeader{Algorithm: s.algorithm, Type: "JWT", KeyID: s.current})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := s.sign(s.keys[s.current], input)
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the token's signature and expiry and returns its claims.
+// Only the configured algorithm is accepted.
+func (s *TokenSigner) Verify(token string, now time.Time) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidToken
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	var header tokenHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil || header.Algorithm != s.algorithm {
+		return claims, ErrInvalidToken
+	}
+	key, ok := s.keys[header.KeyID]
+	if !ok {
+		return claims, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	input := parts[0] + "." + parts[1]
+	if s.algorithm == AlgorithmEdDSA {
+		ok = ed25519.Verify(key.public, []byte(input), signature)
+	} else {
+		ok = hmac.Equal(signature, s.sign(key, input))
+	}
+	if !ok {
+		return claims, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return Claims{}, ErrInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// globEscaper quotes the characters SCAN patterns treat specially.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// DeleteUserData removes what is kept about a deleted user outside their
+// sessions: the task event stream, idempotency keys and login throttle
+// state. Idempotency keys are found with SCAN so Redis is not blocked.
+func DeleteUserData(ctx context.Context, client *redis.Client, username string) error {
+	keys := []string{
+		taskEventsKey + username,
+		userKey(loginFailPrefix, username),
+		userKey(loginLockPrefix, username),
+		userKey(loginWaitPrefix, username),
+		userKey(loginInFlightPrefix, username),
+	}
+	iter := client.Scan(ctx, 0, idempotencyKey(globEscaper.Replace(username), "*"), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return client.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"speechToText/src/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// taskEventsKey prefixes both the per-user pub/sub channel used for live
+	// fan-out and the capped stream kept for Last-Event-ID replay.
+	taskEventsKey       = "tasks:events:"
+	taskEventsMaxLen    = 1000
+	taskEventsRetention = 24 * time.Hour
+)
+
+type taskEventMessage struct {
+	ID    string          `json:"id"`
+	Event types.TaskEvent `json:"event"`
+}
+
+// PublishTaskEvent appends the event to the owner's replay stream and
+// broadcasts it to every API instance.
+func (p *PubSub) PublishTaskEvent(ctx context.Context, event types.TaskEvent) error {
+	key := taskEventsKey + event.Username
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	id, err := p.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: taskEventsMaxLen,
+		Approx: true,
+		Values: map[string]any{"data": data},
+	}).Result()
+	if err != nil {
+		return err
+	}
+	if err := p.Client.Expire(ctx, key, taskEventsRetention).Err(); err != nil {
+		return err
+	}
+	message, err := json.Marshal(taskEventMessage{ID: id, Event: event})
+	if err != nil {
+		return err
+	}
+	return p.Client.Publish(ctx, key, message).Err()
+}
+
+// TaskEvents streams the user's task events. If lastID is set, events after
+// it that are still in the replay stream are sent first. The channel is
+// closed once ctx is done.
+func (p *PubSub) TaskEvents(ctx context.Context, username string, lastID string) (<-chan types.TaskEvent, error) {
+	key := taskEventsKey + username
+	// Subscribe before reading the backlog so nothing published in between
+	// is lost; duplicates are dropped by comparing stream IDs.
+	sub := p.Client.Subscribe(ctx, key)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	if _, _, ok := ParseStreamID(lastID); !ok {
+		lastID = ""
+	}
+	var backlog []redis.XMessage
+	if lastID != "" {
+		var err error
+		backlog, err = p.Client.XRange(ctx, key, "("+lastID, "+").Result()
+		if err != nil {
+			sub.Close()
+			return nil, err
+		}
+	}
+
+	out := make(chan types.TaskEvent)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+
+		send := func(event types.TaskEvent) bool {
+			select {
+			case out <- event:
+				lastID = event.ID
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, msg := range backlog {
+			data, _ := msg.Values["data"].(string)
+			var event types.TaskEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				continue
+			}
+			event.ID = msg.ID
+			if !send(event) {
+				return
+			}
+		}
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var message taskEventMessage
+				if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+					continue
+				}
+				if lastID != "" && !StreamIDAfter(message.ID, lastID) {
+					continue
+				}
+				message.Event.ID = message.ID
+				if !send(message.Event) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// StreamIDAfter reports whether Redis stream ID a ("<ms>-<seq>") is after b.
+func StreamIDAfter(a string, b string) bool {
+	aMs, aSeq, _ := ParseStreamID(a)
+	bMs, bSeq, _ := ParseStreamID(b)
+	if aMs != bMs {
+		return aMs > bMs
+	}
+	return aSeq > bSeq
+}
+
+// ParseStreamID splits a Redis stream ID into its millisecond time and
+// sequence number, reporting whether id is well-formed.
+func ParseStreamID(id string) (uint64, uint64, bool) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	ms, msErr := strconv.ParseUint(msPart, 10, 64)
+	seq, seqErr := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq, found && msErr == nil && seqErr == nil
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const idempotencyKeyPrefix = "idempotency:"
+
+func NewIdempotencyStore(client *redis.Client, ttl time.Duration, lockTTL time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{Client: client, TTL: ttl, LockTTL: lockTTL}
+}
+
+func idempotencyKey(username string, key string) string {
+	return idempotencyKeyPrefix + username + ":" + key
+}
+
+// Reserve claims the key for a new request. If the key was already used it
+// returns the stored record and false instead.
+func (s *IdempotencyStore) Reserve(ctx context.Context, username string, key string, fingerprint string) (*IdempotencyRecord, bool, error) {
+	pending, err := json.Marshal(IdempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, false, err
+	}
+	redisKey := idempotencyKey(username, key)
+	for {
+		ok, err := s.Client.SetNX(ctx, redisKey, pending, s.LockTTL).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			return nil, true, nil
+		}
+		data, err := s.Client.Get(ctx, redisKey).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// Expired between SETNX and GET; try to claim it again.
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var record IdempotencyRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, false, err
+		}
+		return &record, false, nil
+	}
+}
+
+// Complete stores the response of the request that reserved the key for
+// the full TTL.
+func (s *IdempotencyStore) Complete(ctx context.Context, username string, key string, record IdempotencyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(ctx, idempotencyKey(username, key), data, s.TTL).Err()
+}
+
+// Release frees the key after a failed request so the client can retry it.
+func (s *IdempotencyStore) Release(ctx context.Context, username string, key string) error {
+	return s.Client.Del(ctx, idempotencyKey(username, key)).Err()
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"speechToText/src/config"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	loginFailPrefix     = "login:fail:"
+	loginLockPrefix     = "login:lock:"
+	loginWaitPrefix     = "login:wait:"
+	loginInFlightPrefix = "login:inflight:"
+
+	// loginAttemptTTL bounds how long a reservation outlives an attempt
+	// whose guard never released it.
+	loginAttemptTTL = 30 * time.Second
+	// loginBusyWait is the wait reported while another attempt of the same
+	// username is being checked.
+	loginBusyWait = time.Second
+)
+
+func NewLoginThrottle(client *redis.Client, cfg *config.LoginConfig) *LoginThrottle {
+	return &LoginThrottle{Client: client, Config: cfg}
+}
+
+func userKey(prefix string, username string) string {
+	return prefix + "user:" + username
+}
+
+func ipKey(prefix string, ip string) string {
+	return prefix + "ip:" + ip
+}
+
+// LoginDelay returns how long a username must wait before its next attempt
+// after failures consecutive failures: BaseDelay, doubling with each
+// failure, capped at MaxDelay.
+func LoginDelay(cfg *config.LoginConfig, failures int64) time.Duration {
+	if failures < 1 || cfg.BaseDelay <= 0 {
+		return 0
+	}
+	if failures > 32 {
+		return cfg.MaxDelay
+	}
+	d := cfg.BaseDelay << (failures - 1)
+	if d > cfg.MaxDelay || d <= 0 {
+		return cfg.MaxDelay
+	}
+	return d
+}
+
+// Wait returns how long the username or address is still locked out or
+// delayed, or zero if it may try to log in now.
+func (t *LoginThrottle) Wait(ctx context.Context, username string, ip string) (time.Duration, error) {
+	var ttls []*redis.DurationCmd
+	_, err := t.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		ttls = append(ttls,
+			pipe.PTTL(ctx, userKey(loginLockPrefix, username)),
+			pipe.PTTL(ctx, ipKey(loginLockPrefix, ip)),
+			pipe.PTTL(ctx, userKey(loginWaitPrefix, username)),
+		)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var wait time.Duration
+	for _, ttl := range ttls {
+		// Missing keys report a negative TTL.
+		wait = max(wait, ttl.Val())
+	}
+	return wait, nil
+}
+
+// Reserve claims the username's next attempt before its password is
+// checked, so that parallel attempts cannot all pass Wait before the first
+// failure is recorded. It returns a wait greater than zero if the username
+// or address must wait, or another attempt of the username is in progress;
+// otherwise the caller must Release the reservation once the attempt is
+// recorded.
+func (t *LoginThrottle) Reserve(ctx context.Context, username string, ip string) (time.Duration, error) {
+	reserved, err := t.Client.SetNX(ctx, userKey(loginInFlightPrefix, username), "", loginAttemptTTL).Result()
+	if err != nil {
+		return 0, err
+	}
+	if !reserved {
+		return loginBusyWait, nil
+	}
+	wait, err := t.Wait(ctx, username, ip)
+	if err != nil || wait > 0 {
+		return wait, errors.Join(err, t.Release(ctx, username))
+	}
+	return 0, nil
+}
+
+// Release ends the attempt reserved by Reserve.
+func (t *LoginThrottle) Release(ctx context.Context, username string) error {
+	return t.Client.Del(ctx, userKey(loginInFlightPrefix, username)).Err()
+}
+
+// Fail records a failed login of the username from the address, delays the
+// username's next attempt and locks out the username or address once it
+// reaches its limit within the window.
+func (t *LoginThrottle) Fail(ctx context.Context, username string, ip string) (LoginFailure, error) {
+	var failure LoginFailure
+	userFail, ipFail := userKey(loginFailPrefix, username), ipKey(loginFailPrefix, ip)
+	var userCount, ipCount *redis.IntCmd
+	_, err := t.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		userCount = pipe.Incr(ctx, userFail)
+		pipe.ExpireNX(ctx, userFail, t.Config.Window)
+		ipCount = pipe.Incr(ctx, ipFail)
+		pipe.ExpireNX(ctx, ipFail, t.Config.Window)
+		return nil
+	})
+	if err != nil {
+		return failure, err
+	}
+	failure.Failures, failure.IPFailures = userCount.Val(), ipCount.Val()
+
+	if delay := LoginDelay(t.Config, failure.Failures); delay > 0 {
+		if err := t.Client.Set(ctx, userKey(loginWaitPrefix, username), "", delay).Err(); err != nil {
+			return failure, err
+		}
+	}
+	if failure.UserLocked, err = t.lock(ctx, userKey(loginLockPrefix, username), userFail, failure.Failures, t.Config.MaxFailures); err != nil {
+		return failure, err
+	}
+	failure.IPLocked, err = t.lock(ctx, ipKey(loginLockPrefix, ip), ipFail, failure.IPFailures, t.Config.MaxIPFailures)
+	return failure, err
+}
+
+// lock starts a lockout once count reaches limit and resets the count, so
+// the next lockout needs as many failures again. It reports whether this
+// call started the lockout.
+func (t *LoginThrottle) lock(ctx context.Context, lockKey string, failKey string, count int64, limit int) (bool, error) {
+	if limit < 1 || count < int64(limit) {
+		return false, nil
+	}
+	locked, err := t.Client.SetNX(ctx, lockKey, "", t.Config.Lockout).Result()
+	if err != nil {
+		return false, err
+	}
+	return locked, t.Client.Del(ctx, failKey).Err()
+}
+
+// Succeed clears the failures of the username after a successful login.
+// Failures of the address are kept, so that one valid account does not let
+// an address guess the passwords of others.
+func (t *LoginThrottle) Succeed(ctx context.Context, username string) error {
+	return t.Client.Del(ctx, userKey(loginFailPrefix, username), userKey(loginWaitPrefix, username)).Err()
+}
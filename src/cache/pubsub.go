@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CancelChannel carries the IDs of tasks whose in-flight processing must stop.
+const CancelChannel = "tasks:cancel"
+
+func NewPubSub(client *redis.Client) *PubSub {
+	return &PubSub{Client: client}
+}
+
+func (p *PubSub) PublishCancel(ctx context.Context, taskID string) error {
+	return p.Client.Publish(ctx, CancelChannel, taskID).Err()
+}
+
+// SubscribeCancel returns a channel of cancelled task IDs. It is closed once
+// ctx is done.
+func (p *PubSub) SubscribeCancel(ctx context.Context) <-chan string {
+	sub := p.Client.Subscribe(ctx, CancelChannel)
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case out <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -2,24 +2,68 @@ package cache
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	sessionKeyPrefix      = "session:"
+	userSessionsKeyPrefix = "user_sessions:"
+)
+
+// Fields of the session hash besides values set by the application.
+const (
+	sessionUsername   = "username"
+	sessionCreatedAt  = "created_at"
+	sessionLastSeenAt = "last_seen_at"
+	sessionIP         = "ip"
+	sessionUserAgent  = "user_agent"
+)
+
+func sessionKey(sessionID string) string {
+	return sessionKeyPrefix + sessionID
+}
+
+func userSessionsKey(username string) string {
+	return userSessionsKeyPrefix + username
+}
+
+// hsetIfExists sets fields of a hash only if it still exists. A plain HSET
+// on a session that just expired would recreate it without a TTL.
+var hsetIfExists = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("HSET", KEYS[1], unpack(ARGV))
+end
+return 0`)
+
+// Set stores a value in the session. It does nothing if the session has
+// expired or been revoked.
 func (session RedisSession) Set(ctx context.Context, key string, value interface{}) error {
-	return session.Client.Set(ctx, session.SessionId+key, value, session.TTL).Err()
+	return hsetIfExists.Run(ctx, session.Client, []string{sessionKey(session.SessionId)}, key, value).Err()
 }
 
 func (session RedisSession) Get(ctx context.Context, key string) (string, error) {
-	return session.Client.Get(ctx, session.SessionId+key).Result()
+	return session.Client.HGet(ctx, sessionKey(session.SessionId), key).Result()
 }
 
 func (session RedisSession) Delete(ctx context.Context, key string) error {
-	return session.Client.Del(ctx, session.SessionId+key).Err()
+	return session.Client.HDel(ctx, sessionKey(session.SessionId), key).Err()
+}
+
+// Handle identifies the session in listings without revealing its ID,
+// which is the cookie value.
+func (session RedisSession) Handle() string {
+	sum := sha256.Sum256([]byte(session.SessionId))
+	return hex.EncodeToString(sum[:8])
 }
 
 func NewRedisSessionProvider(address string) RedisSessionProvider {
@@ -34,25 +78,36 @@ func (p *RedisSessionProvider) Close() error {
 	return p.Client.Close()
 }
 
-func (sessionProvider *RedisSessionProvider) SessionRead(sessionID string, ctx context.Context, ttl time.Duration) (*RedisSession, error) {
-	exists, err := sessionProvider.Client.Exists(ctx, sessionID).Result()
+// read loads the session hash. It returns nil if the session does not
+// exist or has expired.
+func (manager *RedisSessionManager) read(ctx context.Context, sessionID string) (*RedisSession, error) {
+	fields, err := manager.Provider.Client.HGetAll(ctx, sessionKey(sessionID)).Result()
 	if err != nil {
 		return nil, err
 	}
-	if exists == 0 {
-		if err = sessionProvider.Client.Set(ctx, sessionID, "", ttl).Err(); err != nil {
-			return nil, err
-		}
+	if len(fields) == 0 {
+		return nil, nil
 	}
-	return &RedisSession{
-		SessionId: sessionID,
-		Client:    sessionProvider.Client,
-		TTL:       ttl,
-	}, nil
+	return manager.fromFields(sessionID, fields), nil
 }
 
-func (sessionProvider *RedisSessionProvider) SessionDelete(sessionID string, ctx context.Context) error {
-	return sessionProvider.Client.Del(ctx, sessionID).Err()
+func (manager *RedisSessionManager) fromFields(sessionID string, fields map[string]string) *RedisSession {
+	session := &RedisSession{
+		SessionId: sessionID,
+		Username:  fields[sessionUsername],
+		IP:        fields[sessionIP],
+		UserAgent: fields[sessionUserAgent],
+		Values:    fields,
+		Client:    manager.Provider.Client,
+		TTL:       manager.MaxLifetime,
+	}
+	if unix, err := strconv.ParseInt(fields[sessionCreatedAt], 10, 64); err == nil {
+		session.CreatedAt = time.Unix(unix, 0).UTC()
+	}
+	if unix, err := strconv.ParseInt(fields[sessionLastSeenAt], 10, 64); err == nil {
+		session.LastSeenAt = time.Unix(unix, 0).UTC()
+	}
+	return session
 }
 
 func NewRedisSessionManager(cookieName string, provider RedisSessionProvider, maxLifeTime int64) *RedisSessionManager {
@@ -74,57 +129,99 @@ func (manager *RedisSessionManager) SessionGet(ctx context.Context, r *http.Requ
 	if err != nil || cookie.Value == "" {
 		return nil, fmt.Errorf("no session cookie")
 	}
-	exists, err := manager.Provider.Client.Exists(ctx, cookie.Value).Result()
+	session, err := manager.read(ctx, cookie.Value)
 	if err != nil {
 		return nil, err
 	}
-	if exists == 0 {
+	if session == nil {
 		return nil, fmt.Errorf("session not found")
 	}
-	return &RedisSession{
-		SessionId: cookie.Value,
-		Client:    manager.Provider.Client,
-		TTL:       manager.MaxLifetime,
-	}, nil
+	return session, nil
 }
 
-// SessionStart reads an existing session or creates a new one if none exists.
-// Use only when a session must be created (e.g. on login).
-func (manager *RedisSessionManager) SessionStart(ctx context.Context, w http.ResponseWriter, r *http.Request) (*RedisSession, error) {
-	cookie, err := r.Cookie(manager.Cookie)
-	if err != nil || cookie.Value == "" {
-		sid, err := manager.GenerateSessionID()
-		if err != nil {
-			return nil, err
-		}
-		session, err := manager.Provider.SessionRead(sid, ctx, manager.MaxLifetime)
-		if err != nil {
+// SessionCreate starts a new session of the user with the given values,
+// adds it to the user's index and sets the cookie. A session the request
+// already had is destroyed, so a login always gets a fresh ID.
+func (manager *RedisSessionManager) SessionCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, username string, ip string, values map[string]string) (*RedisSession, error) {
+	if cookie, err := r.Cookie(manager.Cookie); err == nil && cookie.Value != "" {
+		if err := manager.destroy(ctx, cookie.Value); err != nil {
 			return nil, err
 		}
-		http.SetCookie(w, &http.Cookie{
-			Name:     manager.Cookie,
-			Value:    sid,
-			Path:     "/",
-			HttpOnly: true,
-			MaxAge:   int(manager.MaxLifetime.Seconds()),
-		})
-		return session, nil
 	}
-	return manager.Provider.SessionRead(cookie.Value, ctx, manager.MaxLifetime)
+	sid, err := manager.GenerateSessionID()
+	if err != nil {
+		return nil, err
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	fields := map[string]string{
+		sessionUsername:   username,
+		sessionCreatedAt:  now,
+		sessionLastSeenAt: now,
+		sessionIP:         ip,
+		sessionUserAgent:  r.UserAgent(),
+	}
+	for key, value := range values {
+		fields[key] = value
+	}
+	client := manager.Provider.Client
+	if _, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, sessionKey(sid), fields)
+		pipe.Expire(ctx, sessionKey(sid), manager.MaxLifetime)
+		pipe.SAdd(ctx, userSessionsKey(username), sid)
+		// Every session has the same lifetime, so the index outlives them
+		// all.
+		pipe.Expire(ctx, userSessionsKey(username), manager.MaxLifetime)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     manager.Cookie,
+		Value:    sid,
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   int(manager.MaxLifetime.Seconds()),
+	})
+	return manager.fromFields(sid, fields), nil
+}
+
+// Touch records that the session was used now from ip, unless it has
+// expired or been revoked meanwhile.
+func (manager *RedisSessionManager) Touch(ctx context.Context, session *RedisSession, ip string) error {
+	return hsetIfExists.Run(ctx, manager.Provider.Client, []string{sessionKey(session.SessionId)},
+		sessionLastSeenAt, strconv.FormatInt(time.Now().Unix(), 10),
+		sessionIP, ip,
+	).Err()
+}
+
+// destroy deletes the session and removes it from its user's index.
+func (manager *RedisSessionManager) destroy(ctx context.Context, sessionID string) error {
+	client := manager.Provider.Client
+	username, err := client.HGet(ctx, sessionKey(sessionID), sessionUsername).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionKey(sessionID))
+		if username != "" {
+			pipe.SRem(ctx, userSessionsKey(username), sessionID)
+		}
+		return nil
+	})
+	return err
+}
+
+// RevokeSession deletes a session other than the caller's own, whose cookie
+// is left alone.
+func (manager *RedisSessionManager) RevokeSession(ctx context.Context, sessionID string) error {
+	return manager.destroy(ctx, sessionID)
 }
 
 // SessionDestroy deletes the session from Redis and clears the cookie.
 func (manager *RedisSessionManager) SessionDestroy(ctx context.Context, w http.ResponseWriter, sessionID string) error {
-	// Delete all keys belonging to this session (root key + all data keys like sessionID+"username")
-	keys, err := manager.Provider.Client.Keys(ctx, sessionID+"*").Result()
-	if err != nil {
+	if err := manager.destroy(ctx, sessionID); err != nil {
 		return err
 	}
-	if len(keys) > 0 {
-		if err := manager.Provider.Client.Del(ctx, keys...).Err(); err != nil {
-			return err
-		}
-	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     manager.Cookie,
 		Value:    "",
@@ -134,3 +231,64 @@ func (manager *RedisSessionManager) SessionDestroy(ctx context.Context, w http.R
 	})
 	return nil
 }
+
+// UserSessions returns the user's live sessions, most recently used first,
+// and drops expired ones from the index.
+func (manager *RedisSessionManager) UserSessions(ctx context.Context, username string) ([]*RedisSession, error) {
+	client := manager.Provider.Client
+	ids, err := client.SMembers(ctx, userSessionsKey(username)).Result()
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	if _, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, sessionKey(id))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sessions := []*RedisSession{}
+	var expired []any
+	for i, cmd := range cmds {
+		if fields := cmd.Val(); len(fields) > 0 {
+			sessions = append(sessions, manager.fromFields(ids[i], fields))
+		} else {
+			expired = append(expired, ids[i])
+		}
+	}
+	if len(expired) > 0 {
+		if err := client.SRem(ctx, userSessionsKey(username), expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSessions deletes every session of the user except the one with ID
+// keep, and returns how many were deleted.
+func (manager *RedisSessionManager) RevokeSessions(ctx context.Context, username string, keep string) (int, error) {
+	client := manager.Provider.Client
+	ids, err := client.SMembers(ctx, userSessionsKey(username)).Result()
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			if id == keep {
+				continue
+			}
+			pipe.Del(ctx, sessionKey(id))
+			pipe.SRem(ctx, userSessionsKey(username), id)
+			revoked++
+		}
+		return nil
+	})
+	return revoked, err
+}
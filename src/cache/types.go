@@ -1,14 +1,24 @@
 package cache
 
 import (
-	"github.com/redis/go-redis/v9"
+	"speechToText/src/config"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
+// RedisSession is a login session, kept as a Redis hash and listed in its
+// user's index. Values holds every field of the hash.
 type RedisSession struct {
-	SessionId string
-	Client    *redis.Client
-	TTL       time.Duration
+	SessionId  string
+	Username   string
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	Values     map[string]string
+	Client     *redis.Client
+	TTL        time.Duration
 }
 
 type RedisSessionProvider struct {
@@ -20,3 +30,40 @@ type RedisSessionManager struct {
 	Cookie      string
 	MaxLifetime time.Duration
 }
+
+type PubSub struct {
+	Client *redis.Client
+}
+
+// IdempotencyStore keeps responses for TTL. A key reserved by a request
+// still running is held for LockTTL only, so a request that never finishes
+// does not block its key for the whole TTL.
+type IdempotencyStore struct {
+	Client  *redis.Client
+	TTL     time.Duration
+	LockTTL time.Duration
+}
+
+// IdempotencyRecord is what is kept under an Idempotency-Key. Status is zero
+// while the original request is still being processed.
+type IdempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// LoginThrottle counts failed logins per username and per client address.
+type LoginThrottle struct {
+	Client *redis.Client
+	Config *config.LoginConfig
+}
+
+// LoginFailure is the state after a failed login. UserLocked and IPLocked
+// report a lockout that this failure started.
+type LoginFailure struct {
+	Failures   int64
+	IPFailures int64
+	UserLocked bool
+	IPLocked   bool
+}
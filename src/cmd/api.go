@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"speechToText/src/api"
+	"speechToText/src/auth"
+	"speechToText/src/cache"
+	"speechToText/src/config"
+	"speechToText/src/db"
+	"speechToText/src/docs"
+	appmetrics "speechToText/src/metrics"
+	"speechToText/src/storage"
+	"speechToText/src/types"
+	"time"
+
+	httpSwagger "github.com/swaggo/http-swagger"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// runAPI serves the HTTP API until ctx is cancelled. ready handles the
+// readiness probe.
+func runAPI(ctx context.Context, store *db.Store, sessionProvider cache.RedisSessionProvider, pubsub *cache.PubSub, files storage.Storage, ready http.HandlerFunc) {
+	sessionManager := cache.NewRedisSessionManager("session_id", sessionProvider, int64(math.Pow10(5)))
+
+	tokens, err := auth.NewTokenSigner(config.CurrentConfig.Token)
+	if err != nil {
+		log.Fatalf("token keys: %v", err)
+	}
+	handlers := api.NewHandlers(store, sessionManager, pubsub, files, tokens)
+	authMiddleware := auth.NewMiddleware(sessionManager, store, tokens)
+	loginThrottle := cache.NewLoginThrottle(sessionProvider.Client, config.CurrentConfig.Login)
+	loginMetrics := appmetrics.NewLoginMetrics()
+	loginGuard := api.NewLoginGuard(loginThrottle, store, loginMetrics)
+	passwordGuard := api.NewPasswordGuard(loginThrottle, store, loginMetrics)
+	idempotencyMiddleware := api.NewIdempotencyMiddleware(
+		cache.NewIdempotencyStore(sessionProvider.Client, config.CurrentConfig.Redis.IdempotencyTTL, config.CurrentConfig.Redis.IdempotencyLockTTL),
+	)
+
+	docs.SwaggerInfo.Title = "Speech to Text API"
+	docs.SwaggerInfo.Description = "Speech to Text API with pagination"
+	docs.SwaggerInfo.Version = "1.0"
+	docs.SwaggerInfo.Host = "localhost:" + config.CurrentConfig.Server.Port
+	docs.SwaggerInfo.BasePath = "/"
+
+	m := appmetrics.NewMetrics()
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+
+	r.Get("/swagger/*", httpSwagger.Handler(
+		httpSwagger.URL(fmt.Sprintf("http://localhost:%s/swagger/doc.json", config.CurrentConfig.Server.Port)),
+	))
+
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	r.Get("/ready", ready)
+	r.Get("/metrics", promhttp.Handler().ServeHTTP)
+
+	r.Post("/register", handlers.Register)
+	r.With(loginGuard).Post("/login", handlers.Login)
+	r.Post("/token/refresh", handlers.RefreshToken)
+
+	scope := auth.RequireScope
+	r.With(authMiddleware, auth.RequireLogin).Post("/logout", handlers.Logout)
+	r.With(authMiddleware, auth.RequireLogin).Get("/me", handlers.Me)
+	r.With(authMiddleware, auth.RequireLogin, passwordGuard).Post("/me/password", handlers.ChangePassword)
+	r.With(authMiddleware, auth.RequireLogin, passwordGuard).Delete("/me", handlers.DeleteAccount)
+	r.With(authMiddleware, auth.RequireLogin).Get("/sessions", handlers.Sessions)
+	r.With(authMiddleware, auth.RequireLogin).Delete("/sessions", handlers.RevokeAllSessions)
+	r.With(authMiddleware, auth.RequireLogin).Delete("/sessions/{id}", handlers.RevokeSession)
+	r.With(authMiddleware, scope(types.ScopeTasksWrite), idempotencyMiddleware).Post("/audio", handlers.Audio)
+	r.With(authMiddleware, scope(types.ScopeTasksWrite), idempotencyMiddleware).Post("/audio/batch", handlers.AudioBatch)
+	r.With(authMiddleware, scope(types.ScopeTasksRead)).Get("/batches/{id}", handlers.Batch)
+	r.With(authMiddleware, scope(types.ScopeTasksRead)).Get("/batches/{id}/export", handlers.BatchExport)
+	r.With(authMiddleware, scope(types.ScopeTasksRead)).Get("/status", handlers.Status)
+	r.With(authMiddleware, scope(types.ScopeTasksRead)).Get("/result", handlers.Result)
+	r.With(authMiddleware, scope(types.ScopeTasksRead)).Get("/tasks", handlers.Tasks)
+	r.With(authMiddleware, scope(types.ScopeTasksRead)).Get("/tasks/events", handlers.TaskEvents)
+	r.With(authMiddleware, scope(types.ScopeTasksRead)).Get("/tasks/{id}/events", handlers.TaskEventsByID)
+	r.With(authMiddleware, scope(types.ScopeTasksDelete)).Delete("/tasks/{id}", handlers.DeleteTask)
+	r.With(authMiddleware, scope(types.ScopeTasksWrite)).Post("/tasks/{id}/cancel", handlers.CancelTask)
+	r.With(authMiddleware, scope(types.ScopeTasksWrite)).Post("/tasks/{id}/retry", handlers.RetryTask)
+	r.With(authMiddleware, scope(types.ScopeTasksWrite)).Post("/tasks/retry", handlers.RetryTasks)
+	r.With(authMiddleware, scope(types.ScopeTasksRead)).Get("/tasks/{id}/attempts", handlers.TaskAttempts)
+
+	r.With(authMiddleware, scope(types.ScopeTasksRead), idempotencyMiddleware).Post("/exports", handlers.CreateExport)
+	r.With(authMiddleware, scope(types.ScopeTasksRead)).Get("/exports/{id}", handlers.Export)
+	r.Get("/exports/{id}/download", handlers.DownloadExport)
+
+	r.With(authMiddleware, scope(types.ScopeSettingsRead)).Get("/retention", handlers.Retention)
+	r.With(authMiddleware, scope(types.ScopeSettingsWrite)).Put("/retention", handlers.UpdateRetention)
+	r.With(authMiddleware, scope(types.ScopeSettingsRead)).Get("/retention/purges", handlers.RetentionPurges)
+
+	r.With(authMiddleware, scope(types.ScopeWebhooksWrite)).Post("/webhooks", handlers.CreateWebhook)
+	r.With(authMiddleware, scope(types.ScopeWebhooksRead)).Get("/webhooks", handlers.Webhooks)
+	r.With(authMiddleware, scope(types.ScopeWebhooksWrite)).Post("/webhooks/callback-secret", handlers.RotateCallbackSecret)
+	r.With(authMiddleware, scope(types.ScopeWebhooksWrite)).Delete("/webhooks/{id}", handlers.DeleteWebhook)
+	r.With(authMiddleware, scope(types.ScopeWebhooksRead)).Get("/webhooks/deliveries", handlers.WebhookDeliveries)
+	r.With(authMiddleware, scope(types.ScopeWebhooksWrite)).Post("/webhooks/deliveries/{id}/redeliver", handlers.RedeliverWebhook)
+
+	r.With(authMiddleware, auth.RequireLogin).Post("/keys", handlers.CreateAPIKey)
+	r.With(authMiddleware, auth.RequireLogin).Get("/keys", handlers.APIKeys)
+	r.With(authMiddleware, auth.RequireLogin).Delete("/keys/{id}", handlers.RevokeAPIKey)
+
+	staff := auth.RequireRole(types.RoleAdmin, types.RoleSupport)
+	admin := auth.RequireRole(types.RoleAdmin)
+	r.With(authMiddleware, auth.RequireLogin, staff).Get("/admin/users", handlers.AdminUsers)
+	r.With(authMiddleware, auth.RequireLogin, admin).Post("/admin/users/{username}/disable", handlers.DisableUser)
+	r.With(authMiddleware, auth.RequireLogin, admin).Post("/admin/users/{username}/enable", handlers.EnableUser)
+	r.With(authMiddleware, auth.RequireLogin, admin).Put("/admin/users/{username}/role", handlers.SetUserRole)
+	r.With(authMiddleware, auth.RequireLogin, staff).Get("/admin/tasks/{id}", handlers.AdminTask)
+	r.With(authMiddleware, auth.RequireLogin, staff).Post("/admin/tasks/{id}/requeue", handlers.AdminRequeueTask)
+	r.With(authMiddleware, auth.RequireLogin, staff).Get("/admin/stats", handlers.AdminStats)
+	r.With(authMiddleware, auth.RequireLogin, admin).Get("/admin/audit", handlers.AuditLog)
+
+	server := &http.Server{
+		Addr:         ":" + config.CurrentConfig.Server.Port,
+		Handler:      r,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	log.Printf("Server started on port %s\n", config.CurrentConfig.Server.Port)
+	log.Printf("Swagger UI: http://localhost:%s/swagger/index.html\n", config.CurrentConfig.Server.Port)
+	serveHTTP(ctx, server)
+}
@@ -4,27 +4,24 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"math"
 	"net/http"
 	"os"
 	"os/signal"
-	"speechToText/src/api"
-	"speechToText/src/auth"
 	"speechToText/src/cache"
 	"speechToText/src/config"
-	"speechToText/src/consumer"
 	"speechToText/src/db"
-	"speechToText/src/docs"
-	appmetrics "speechToText/src/metrics"
 	"speechToText/src/pkg/closer"
+	"speechToText/src/storage"
 	"sync"
 	"syscall"
 	"time"
+)
 
-	httpSwagger "github.com/swaggo/http-swagger"
-
-	"github.com/go-chi/chi/v5"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+// Run modes, selected by the first command-line argument.
+const (
+	modeServe  = "serve"
+	modeWorker = "worker"
+	modeAll    = "all"
 )
 
 // @title Speech to Text API
@@ -36,6 +33,22 @@ import (
 // @in header
 // @name Authorization
 func main() {
+	mode := modeAll
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+	if mode != modeServe && mode != modeWorker && mode != modeAll {
+		log.Fatalf("unknown mode %q, expected %s, %s or %s", mode, modeServe, modeWorker, modeAll)
+	}
+	if err := config.CurrentConfig.Validate(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if mode != modeAll {
+		if err := checkSplitExports(mode); err != nil {
+			log.Fatalf("export config: %v", err)
+		}
+	}
+
 	cl := closer.New()
 
 	sqlDB, err := db.NewDB(config.CurrentConfig.Database)
@@ -47,90 +60,78 @@ func main() {
 
 	sessionProvider := cache.NewRedisSessionProvider(config.CurrentConfig.Redis.Host)
 	cl.Add(sessionProvider.Close)
-	sessionManager := cache.NewRedisSessionManager("session_id", sessionProvider, int64(math.Pow10(5)))
-
-	producer := consumer.NewProducer(config.CurrentConfig.RabbitMQ.Url)
-	cl.Add(producer.Close)
-
-	cons := consumer.NewConsumer(store)
-
-	handlers := api.NewHandlers(store, sessionManager, producer)
-	authMiddleware := auth.NewMiddleware(sessionManager)
+	pubsub := cache.NewPubSub(sessionProvider.Client)
 
-	docs.SwaggerInfo.Title = "Speech to Text API"
-	docs.SwaggerInfo.Description = "Speech to Text API with pagination"
-	docs.SwaggerInfo.Version = "1.0"
-	docs.SwaggerInfo.Host = "localhost:" + config.CurrentConfig.Server.Port
-	docs.SwaggerInfo.BasePath = "/"
-
-	m := appmetrics.NewMetrics()
-	r := chi.NewRouter()
-	r.Use(m.Middleware)
-
-	r.Get("/swagger/*", httpSwagger.Handler(
-		httpSwagger.URL(fmt.Sprintf("http://localhost:%s/swagger/doc.json", config.CurrentConfig.Server.Port)),
-	))
-
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-	r.Get("/metrics", promhttp.Handler().ServeHTTP)
-
-	r.Post("/register", handlers.Register)
-	r.Post("/login", handlers.Login)
-
-	r.With(authMiddleware).Post("/logout", handlers.Logout)
-	r.With(authMiddleware).Post("/audio", handlers.Audio)
-	r.With(authMiddleware).Get("/status", handlers.Status)
-	r.With(authMiddleware).Get("/result", handlers.Result)
-	r.With(authMiddleware).Get("/tasks", handlers.Tasks)
-	r.With(authMiddleware).Delete("/tasks/{id}", handlers.DeleteTask)
+	files, err := storage.NewLocal(config.CurrentConfig.Export.Dir)
+	if err != nil {
+		log.Fatalf("export storage init: %v", err)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := cons.Receive("queue", ctx); err != nil {
-			log.Println("consumer error:", err)
-		}
-	}()
-
-	server := &http.Server{
-		Addr:         ":" + config.CurrentConfig.Server.Port,
-		Handler:      r,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	// The API is always ready; in all mode it reports the worker's readiness.
+	ready := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
 	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		log.Printf("Server started on port %s\n", config.CurrentConfig.Server.Port)
-		log.Printf("Swagger UI: http://localhost:%s/swagger/index.html\n", config.CurrentConfig.Server.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Server error: %v\n", err)
-		}
-	}()
+	var wg sync.WaitGroup
+	if mode == modeWorker || mode == modeAll {
+		wk := newWorker(store, pubsub, files, cl)
+		ready = wk.ready
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// In all mode the API server already exposes the probes.
+			wk.run(ctx, mode == modeWorker)
+		}()
+	}
+	if mode == modeServe || mode == modeAll {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runAPI(ctx, store, sessionProvider, pubsub, files, ready)
+		}()
+	}
+	log.Printf("Running in %s mode\n", mode)
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Received shutdown signal, stopping server...")
+	log.Println("Received shutdown signal, stopping...")
 
 	cancel()
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer shutdownCancel()
+	wg.Wait()
+	cl.Close()
+	log.Println("Stopped successfully")
+}
 
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Error stopping server: %v\n", err)
+// checkSplitExports refuses to start a serve or worker process that would
+// break export downloads. Workers write the files the API serves, so both
+// need EXPORT_DIR pointing at a shared volume, and every API instance must
+// sign links with the same EXPORT_LINK_SECRET.
+func checkSplitExports(mode string) error {
+	if os.Getenv("EXPORT_DIR") == "" {
+		return fmt.Errorf("%s mode needs EXPORT_DIR set to a directory shared by the API and the workers", mode)
+	}
+	if mode == modeServe && config.CurrentConfig.Export.LinkSecret == "" {
+		return fmt.Errorf("%s mode needs EXPORT_LINK_SECRET so download links work on every API instance", mode)
 	}
+	return nil
+}
 
-	wg.Wait()
-	cl.Close()
-	log.Println("Server stopped successfully")
+// serveHTTP runs server until ctx is cancelled and then shuts it down,
+// giving open requests up to 30 seconds to finish.
+func serveHTTP(ctx context.Context, server *http.Server) {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer shutdownCancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error stopping server on %s: %v\n", server.Addr, err)
+		}
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Server error on %s: %v\n", server.Addr, err)
+	}
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"speechToText/src/cache"
+	"speechToText/src/config"
+	"speechToText/src/consumer"
+	"speechToText/src/db"
+	"speechToText/src/export"
+	appmetrics "speechToText/src/metrics"
+	"speechToText/src/pkg/closer"
+	"speechToText/src/storage"
+	"speechToText/src/webhook"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// worker holds the queue consumer and the background loops: outbox relay,
+// reaper, retention purger, webhook dispatcher and exporter.
+type worker struct {
+	cons       *consumer.Consumer
+	relay      *consumer.Relay
+	reaper     *consumer.Reaper
+	purger     *consumer.Purger
+	dispatcher *webhook.Dispatcher
+	exporter   *export.Exporter
+}
+
+func newWorker(store *db.Store, pubsub *cache.PubSub, files storage.Storage, cl *closer.Closer) *worker {
+	producer := consumer.NewProducer(config.CurrentConfig.RabbitMQ.Url)
+	cl.Add(producer.Close)
+
+	return &worker{
+		cons:       consumer.NewConsumer(store, pubsub, config.CurrentConfig.Worker, appmetrics.NewWorkerMetrics()),
+		relay:      consumer.NewRelay(store, producer, config.CurrentConfig.Outbox),
+		reaper:     consumer.NewReaper(store, pubsub, config.CurrentConfig.Reaper),
+		purger:     consumer.NewPurger(store, config.CurrentConfig.Retention),
+		dispatcher: webhook.NewDispatcher(store, config.CurrentConfig.Webhook),
+		exporter:   export.NewExporter(store, files, config.CurrentConfig.Export),
+	}
+}
+
+// ready reports 503 while the consumer has no broker connection.
+func (wk *worker) ready(w http.ResponseWriter, r *http.Request) {
+	if !wk.cons.Connected() {
+		http.Error(w, "not connected to RabbitMQ", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// run runs everything until ctx is cancelled and the consumer has drained.
+// With probes set it also serves /health, /ready and /metrics on the worker
+// probe port.
+func (wk *worker) run(ctx context.Context, probes bool) {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := wk.cons.Receive(consumer.TaskQueue, ctx); err != nil {
+			log.Println("consumer error:", err)
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wk.relay.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wk.reaper.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wk.purger.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wk.dispatcher.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wk.exporter.Run(ctx)
+	}()
+
+	if probes {
+		r := chi.NewRouter()
+		r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		r.Get("/ready", wk.ready)
+		r.Get("/metrics", promhttp.Handler().ServeHTTP)
+
+		server := &http.Server{
+			Addr:        ":" + config.CurrentConfig.Worker.ProbePort,
+			Handler:     r,
+			ReadTimeout: 10 * time.Second,
+			IdleTimeout: 60 * time.Second,
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("Worker probes on port %s\n", config.CurrentConfig.Worker.ProbePort)
+			serveHTTP(ctx, server)
+		}()
+	}
+
+	wg.Wait()
+}
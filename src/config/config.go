@@ -1,35 +1,53 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var CurrentConfig = NewConfig()
 
 type Config struct {
-	Server   *ServerConfig
-	Database *DatabaseConfig
-	Logger   *LoggerConfig
-	RabbitMQ *RabbitMQConfig
-	Redis    *RedisConfig
-	Deepgram *DeepgramConfig
+	Server      *ServerConfig
+	Database    *DatabaseConfig
+	Logger      *LoggerConfig
+	RabbitMQ    *RabbitMQConfig
+	Redis       *RedisConfig
+	Deepgram    *DeepgramConfig
+	Outbox      *OutboxConfig
+	Reaper      *ReaperConfig
+	Webhook     *WebhookConfig
+	Worker      *WorkerConfig
+	Retention   *RetentionConfig
+	Export      *ExportConfig
+	SourceURL   *URLPolicyConfig
+	CallbackURL *URLPolicyConfig
+	Token       *TokenConfig
+	Login       *LoginConfig
 }
 
+// ServerConfig configures the HTTP API. Requests from TrustedProxies
+// (addresses or CIDR ranges) are attributed to the client address in
+// X-Forwarded-For.
 type ServerConfig struct {
-	Port     string
-	Host     string
-	HostPort string
+	Port           string
+	Host           string
+	HostPort       string
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
-	Username     string
-	Name         string
-	Password     string
-	Host         string
-	Port         string
-	DatabaseName string
-	SSLMode      string
-	MigrationPath    string
+	Username      string
+	Name          string
+	Password      string
+	Host          string
+	Port          string
+	DatabaseName  string
+	SSLMode       string
+	MigrationPath string
 }
 
 type LoggerConfig struct {
@@ -37,8 +55,13 @@ type LoggerConfig struct {
 	Format string
 }
 
+// RedisConfig configures Redis. Idempotency keys keep their response for
+// IdempotencyTTL; while the request runs, they are locked for
+// IdempotencyLockTTL, which should cover the longest request.
 type RedisConfig struct {
-	Host string
+	Host               string
+	IdempotencyTTL     time.Duration
+	IdempotencyLockTTL time.Duration
 }
 
 type RabbitMQConfig struct {
@@ -54,15 +77,154 @@ type DeepgramConfig struct {
 	ApiKey string
 }
 
+type OutboxConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	Retention    time.Duration
+}
+
+// ReaperConfig configures the scan for stuck tasks. Active tasks without a
+// heartbeat for Timeout are requeued, up to MaxRequeues times; queued tasks
+// whose message was published more than RepublishAfter ago get it again.
+type ReaperConfig struct {
+	Interval          time.Duration
+	Timeout           time.Duration
+	MaxRequeues       int
+	HeartbeatInterval time.Duration
+	RepublishAfter    time.Duration
+}
+
+type WorkerConfig struct {
+	Concurrency  int
+	Prefetch     int
+	DrainTimeout time.Duration
+	ProbePort    string
+}
+
+// RetentionConfig holds the purge schedule and the retention applied to users
+// without their own settings. Zero days keeps data forever.
+type RetentionConfig struct {
+	Interval       time.Duration
+	BatchSize      int
+	AudioDays      int
+	TranscriptDays int
+}
+
+// ExportConfig configures export jobs. Dir must be shared by the API and the
+// workers when they run as separate processes. Downloads are signed with
+// LinkSecret.
+type ExportConfig struct {
+	Dir          string
+	PollInterval time.Duration
+	StaleAfter   time.Duration
+	Retention    time.Duration
+	LinkTTL      time.Duration
+	LinkSecret   string
+}
+
+// URLPolicyConfig restricts the URLs users may submit, as audio sources or
+// as webhook and callback endpoints. Hosts on AllowHosts skip the address
+// checks, hosts on DenyHosts are always rejected. Entries are host names,
+// "*.domain" patterns, IPs or CIDR ranges.
+type URLPolicyConfig struct {
+	AllowedPorts   []int
+	AllowHosts     []string
+	DenyHosts      []string
+	ResolveTimeout time.Duration
+}
+
+// TokenConfig configures JWT access tokens and refresh tokens. Keys holds
+// "kid=base64key" entries: the first signs new tokens and the rest are still
+// accepted, so keys can be rotated. HS256 keys are secrets, EdDSA keys are
+// Ed25519 seeds.
+type TokenConfig struct {
+	Algorithm  string
+	Keys       []string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// LoginConfig throttles failed logins. After each failure of a username the
+// next attempt must wait BaseDelay, doubling up to MaxDelay; MaxFailures
+// failures within Window lock the username out for Lockout, and
+// MaxIPFailures failures from one address lock the address out.
+type LoginConfig struct {
+	MaxFailures   int
+	MaxIPFailures int
+	Window        time.Duration
+	Lockout       time.Duration
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+}
+
+type WebhookConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	Timeout      time.Duration
+}
+
+func getEnv(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvInts(key string, def []int) []int {
+	items := getEnvList(key, nil)
+	if items == nil {
+		return def
+	}
+	list := make([]int, 0, len(items))
+	for _, item := range items {
+		v, err := strconv.Atoi(item)
+		if err != nil {
+			return def
+		}
+		list = append(list, v)
+	}
+	return list
+}
+
 func NewConfig() *Config {
 	var databaseConfig = DatabaseConfig{
-		Username:     os.Getenv("DB_USER"),
-		Password:     os.Getenv("DB_PASSWORD"),
-		Host:         os.Getenv("DB_HOST"),
-		Port:         os.Getenv("DB_PORT"),
-		Name:         os.Getenv("DB_NAME"),
-		DatabaseName: os.Getenv("DB_NAME"),
-		SSLMode:      os.Getenv("DB_SSL_MODE"),
+		Username:      os.Getenv("DB_USER"),
+		Password:      os.Getenv("DB_PASSWORD"),
+		Host:          os.Getenv("DB_HOST"),
+		Port:          os.Getenv("DB_PORT"),
+		Name:          os.Getenv("DB_NAME"),
+		DatabaseName:  os.Getenv("DB_NAME"),
+		SSLMode:       os.Getenv("DB_SSL_MODE"),
 		MigrationPath: os.Getenv("DB_MIGRATION_PATH"),
 	}
 
@@ -72,13 +234,16 @@ func NewConfig() *Config {
 	}
 
 	var serverConfig = ServerConfig{
-		Port:     os.Getenv("SERVER_PORT"),
-		Host:     os.Getenv("SERVER_HOST"),
-		HostPort: os.Getenv("HOST_PORT"),
+		Port:           os.Getenv("SERVER_PORT"),
+		Host:           os.Getenv("SERVER_HOST"),
+		HostPort:       os.Getenv("HOST_PORT"),
+		TrustedProxies: getEnvList("TRUSTED_PROXIES", nil),
 	}
 
 	var redisConfig = RedisConfig{
-		Host: os.Getenv("REDIS_HOST"),
+		Host:               os.Getenv("REDIS_HOST"),
+		IdempotencyTTL:     getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		IdempotencyLockTTL: getEnvDuration("IDEMPOTENCY_LOCK_TTL", time.Minute),
 	}
 
 	var deepgramConfig = DeepgramConfig{
@@ -93,13 +258,126 @@ func NewConfig() *Config {
 		Password: os.Getenv("RABBITMQ_PASSWORD"),
 		UserPort: os.Getenv("RABBITMQ_USER_PORT"),
 	}
+
+	var outboxConfig = OutboxConfig{
+		PollInterval: getEnvDuration("OUTBOX_POLL_INTERVAL", time.Second),
+		BatchSize:    getEnvInt("OUTBOX_BATCH_SIZE", 100),
+		Retention:    getEnvDuration("OUTBOX_RETENTION", 24*time.Hour),
+	}
+
+	var reaperConfig = ReaperConfig{
+		Interval:          getEnvDuration("REAPER_INTERVAL", time.Minute),
+		Timeout:           getEnvDuration("TASK_VISIBILITY_TIMEOUT", 10*time.Minute),
+		MaxRequeues:       getEnvInt("TASK_MAX_REQUEUES", 3),
+		HeartbeatInterval: getEnvDuration("TASK_HEARTBEAT_INTERVAL", 30*time.Second),
+		RepublishAfter:    getEnvDuration("TASK_REPUBLISH_AFTER", time.Hour),
+	}
+
+	var webhookConfig = WebhookConfig{
+		PollInterval: getEnvDuration("WEBHOOK_POLL_INTERVAL", time.Second),
+		BatchSize:    getEnvInt("WEBHOOK_BATCH_SIZE", 20),
+		MaxAttempts:  getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		Timeout:      getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+	}
+
+	var workerConfig = WorkerConfig{
+		Concurrency:  getEnvInt("WORKER_CONCURRENCY", 4),
+		Prefetch:     getEnvInt("WORKER_PREFETCH", 0),
+		DrainTimeout: getEnvDuration("WORKER_DRAIN_TIMEOUT", 30*time.Second),
+		ProbePort:    getEnv("WORKER_PROBE_PORT", "9091"),
+	}
+	if workerConfig.Concurrency < 1 {
+		workerConfig.Concurrency = 1
+	}
+	if workerConfig.Prefetch < workerConfig.Concurrency {
+		workerConfig.Prefetch = workerConfig.Concurrency
+	}
+
+	var retentionConfig = RetentionConfig{
+		Interval:       getEnvDuration("RETENTION_INTERVAL", time.Hour),
+		BatchSize:      getEnvInt("RETENTION_BATCH_SIZE", 500),
+		AudioDays:      getEnvInt("RETENTION_AUDIO_DAYS", 0),
+		TranscriptDays: getEnvInt("RETENTION_TRANSCRIPT_DAYS", 0),
+	}
+
+	var exportConfig = ExportConfig{
+		Dir:          getEnv("EXPORT_DIR", "exports"),
+		PollInterval: getEnvDuration("EXPORT_POLL_INTERVAL", 5*time.Second),
+		StaleAfter:   getEnvDuration("EXPORT_STALE_AFTER", time.Hour),
+		Retention:    getEnvDuration("EXPORT_RETENTION", 7*24*time.Hour),
+		LinkTTL:      getEnvDuration("EXPORT_LINK_TTL", time.Hour),
+		LinkSecret:   os.Getenv("EXPORT_LINK_SECRET"),
+	}
+
+	var sourceURLConfig = URLPolicyConfig{
+		AllowedPorts:   getEnvInts("SOURCE_URL_ALLOWED_PORTS", []int{80, 443}),
+		AllowHosts:     getEnvList("SOURCE_URL_ALLOW_HOSTS", nil),
+		DenyHosts:      getEnvList("SOURCE_URL_DENY_HOSTS", nil),
+		ResolveTimeout: getEnvDuration("SOURCE_URL_RESOLVE_TIMEOUT", 5*time.Second),
+	}
+
+	var callbackURLConfig = URLPolicyConfig{
+		AllowedPorts:   getEnvInts("CALLBACK_URL_ALLOWED_PORTS", []int{80, 443}),
+		AllowHosts:     getEnvList("CALLBACK_URL_ALLOW_HOSTS", nil),
+		DenyHosts:      getEnvList("CALLBACK_URL_DENY_HOSTS", nil),
+		ResolveTimeout: getEnvDuration("CALLBACK_URL_RESOLVE_TIMEOUT", 5*time.Second),
+	}
+
+	var tokenConfig = TokenConfig{
+		Algorithm:  getEnv("TOKEN_ALGORITHM", "HS256"),
+		Keys:       getEnvList("TOKEN_KEYS", nil),
+		AccessTTL:  getEnvDuration("TOKEN_ACCESS_TTL", 15*time.Minute),
+		RefreshTTL: getEnvDuration("TOKEN_REFRESH_TTL", 30*24*time.Hour),
+	}
+
+	var loginConfig = LoginConfig{
+		MaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),
+		MaxIPFailures: getEnvInt("LOGIN_MAX_IP_FAILURES", 50),
+		Window:        getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		Lockout:       getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		BaseDelay:     getEnvDuration("LOGIN_BASE_DELAY", time.Second),
+		MaxDelay:      getEnvDuration("LOGIN_MAX_DELAY", 30*time.Second),
+	}
+
 	var Config = &Config{
-		Server:   &serverConfig,
-		Database: &databaseConfig,
-		Logger:   &loggerConfig,
-		RabbitMQ: &rabbitMQConfig,
-		Redis:    &redisConfig,
-		Deepgram: &deepgramConfig,
+		Server:      &serverConfig,
+		Database:    &databaseConfig,
+		Logger:      &loggerConfig,
+		RabbitMQ:    &rabbitMQConfig,
+		Redis:       &redisConfig,
+		Deepgram:    &deepgramConfig,
+		Outbox:      &outboxConfig,
+		Reaper:      &reaperConfig,
+		Webhook:     &webhookConfig,
+		Worker:      &workerConfig,
+		Retention:   &retentionConfig,
+		Export:      &exportConfig,
+		SourceURL:   &sourceURLConfig,
+		CallbackURL: &callbackURLConfig,
+		Token:       &tokenConfig,
+		Login:       &loginConfig,
 	}
 	return Config
 }
+
+// Validate reports settings the service cannot start with, such as polling
+// intervals that are not positive.
+func (c *Config) Validate() error {
+	intervals := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"OUTBOX_POLL_INTERVAL", c.Outbox.PollInterval},
+		{"REAPER_INTERVAL", c.Reaper.Interval},
+		{"TASK_HEARTBEAT_INTERVAL", c.Reaper.HeartbeatInterval},
+		{"WEBHOOK_POLL_INTERVAL", c.Webhook.PollInterval},
+		{"RETENTION_INTERVAL", c.Retention.Interval},
+		{"EXPORT_POLL_INTERVAL", c.Export.PollInterval},
+	}
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", interval.name, interval.value)
+		}
+	}
+	return nil
+}
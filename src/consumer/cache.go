@@ -0,0 +1,73 @@
+package consumer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"strings"
+	"time"
+)
+
+// sourceProbeTimeout bounds the HEAD request used to fingerprint a source.
+const sourceProbeTimeout = 10 * time.Second
+
+// Fingerprint identifies a transcription of a source by its URL, the
+// validators the server returned for it and the effective options. It is
+// empty if the server sent neither an ETag nor a Content-Length, since the
+// content behind the URL could then change unnoticed.
+func Fingerprint(audioURL string, header http.Header, opts types.TranscriptionOptions) string {
+	etag := header.Get("ETag")
+	length := header.Get("Content-Length")
+	if etag == "" && length == "" {
+		return ""
+	}
+	if opts.Model == "" {
+		opts.Model = defaultModel
+	}
+	if opts.Language == "" {
+		opts.Language = defaultLanguage
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		audioURL, etag, length, header.Get("Last-Modified"), opts.Model, opts.Language,
+	}, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprint asks the source server for the validators of the audio and
+// returns its fingerprint, or an empty string if it cannot be determined.
+func (c *Consumer) fingerprint(ctx context.Context, audio types.AudioMessage) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, audio.Audio, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := c.probe.Do(req)
+	if err != nil {
+		service.LogInfo("probe source of task %s: %v", audio.TaskID, err)
+		return ""
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ""
+	}
+	return Fingerprint(audio.Audio, resp.Header, audio.TranscriptionOptions)
+}
+
+// fromCache fingerprints the source and, unless the task opted out,
+// completes it with a matching earlier transcript. It returns true if the
+// task was served from the cache.
+func (c *Consumer) fromCache(ctx context.Context, audio types.AudioMessage) (bool, error) {
+	fingerprint := c.fingerprint(ctx, audio)
+	if fingerprint == "" {
+		return false, nil
+	}
+	if !audio.NoCache {
+		cached, err := c.store.CompleteFromCache(audio.TaskID, fingerprint)
+		if err != nil || cached {
+			return cached, err
+		}
+	}
+	return false, c.store.SetTaskFingerprint(audio.TaskID, fingerprint)
+}
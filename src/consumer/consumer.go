@@ -3,15 +3,25 @@ package consumer
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 
+	"speechToText/src/cache"
 	"speechToText/src/config"
 	"speechToText/src/db"
+	appmetrics "speechToText/src/metrics"
+	"speechToText/src/service"
 	"speechToText/src/types"
+	"speechToText/src/urlpolicy"
 )
 
 // Producer manages a lazily-initialised RabbitMQ producer connection.
@@ -56,7 +66,8 @@ func (p *Producer) resetChannel() {
 	p.ch = nil
 }
 
-func (p *Producer) Send(taskID string, queueName string, audioUrl string) error {
+// Publish declares the queue and publishes an already encoded message body.
+func (p *Producer) Publish(queueName string, body []byte) error {
 	ch, err := p.channel()
 	if err != nil {
 		return err
@@ -67,11 +78,6 @@ func (p *Producer) Send(taskID string, queueName string, audioUrl string) error
 		return err
 	}
 
-	body, err := json.Marshal(types.AudioMessage{TaskID: taskID, Audio: audioUrl})
-	if err != nil {
-		return err
-	}
-
 	err = ch.Publish("", queueName, false, false, amqp.Publishing{
 		ContentType: "application/json",
 		Body:        body,
@@ -91,80 +97,423 @@ func (p *Producer) Close() error {
 	return nil
 }
 
-// Consumer processes messages from a RabbitMQ queue.
+var (
+	// errMalformedMessage marks deliveries that can never be processed.
+	errMalformedMessage = errors.New("malformed task message")
+	// errDrainTimeout aborts tasks still running when shutdown stops waiting.
+	errDrainTimeout = errors.New("worker drain timeout exceeded")
+)
+
+// Consumer processes messages from a RabbitMQ queue with a pool of workers.
 type Consumer struct {
-	store *db.Store
+	store   *db.Store
+	pubsub  *cache.PubSub
+	cfg     *config.WorkerConfig
+	metrics *appmetrics.WorkerMetrics
+	sources *urlpolicy.Policy
+	probe   *http.Client
+
+	// base is the parent of every in-flight task context. It is cancelled
+	// with errDrainTimeout when the drain deadline passes.
+	base  context.Context
+	abort context.CancelCauseFunc
+
+	connected atomic.Bool
+
+	mu       sync.Mutex
+	inflight map[string]context.CancelFunc
 }
 
-func NewConsumer(store *db.Store) *Consumer {
-	return &Consumer{store: store}
+func NewConsumer(store *db.Store, pubsub *cache.PubSub, cfg *config.WorkerConfig, m *appmetrics.WorkerMetrics) *Consumer {
+	base, abort := context.WithCancelCause(context.Background())
+	sources := urlpolicy.New(config.CurrentConfig.SourceURL)
+	return &Consumer{
+		store:    store,
+		pubsub:   pubsub,
+		cfg:      cfg,
+		metrics:  m,
+		sources:  sources,
+		probe:    sources.Client(sourceProbeTimeout),
+		base:     base,
+		abort:    abort,
+		inflight: make(map[string]context.CancelFunc),
+	}
 }
 
+// Receive consumes the queue with cfg.Concurrency workers until ctx is
+// cancelled, reconnecting with backoff whenever the broker connection is
+// lost. It then stops taking new messages and waits up to cfg.DrainTimeout
+// for in-flight tasks; tasks still running after that are put back in the
+// queue.
 func (c *Consumer) Receive(queueName string, ctx context.Context) error {
+	// Cancellations must still reach tasks that finish during the drain.
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go c.watchCancellations(watchCtx)
+
+	deliveries := make(chan amqp.Delivery)
+	var workers sync.WaitGroup
+	for i := range c.cfg.Concurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			c.runWorker(ctx, strconv.Itoa(i), deliveries)
+		}()
+	}
+
+	var connection *amqp.Connection
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		conn, messages, err := c.dial(ctx, queueName)
+		if err != nil {
+			delay := ReconnectBackoff(attempt)
+			service.LogError("connect to RabbitMQ: %v, retrying in %s", err, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		connection = conn
+		attempt = -1
+		c.setConnected(true)
+		log.Printf(" [*] %d workers waiting for messages. To exit press CTRL+C", c.cfg.Concurrency)
+
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		if reason := forward(ctx, messages, closed, deliveries); reason != nil {
+			c.setConnected(false)
+			c.metrics.Reconnects.Inc()
+			service.LogError("RabbitMQ connection lost: %v", reason)
+			// Only the channel may have gone away; drop the connection
+			// too so the redial does not leave it open.
+			if !conn.IsClosed() {
+				conn.Close()
+			}
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(c.cfg.DrainTimeout):
+		service.LogInfo("workers not drained after %s, requeueing in-flight tasks", c.cfg.DrainTimeout)
+		c.abort(errDrainTimeout)
+		<-drained
+	}
+
+	// Close only after the drain so in-flight tasks can still be acked.
+	c.setConnected(false)
+	if connection != nil && !connection.IsClosed() {
+		return connection.Close()
+	}
+	return nil
+}
+
+// dial connects to the broker, declares the queue and starts consuming it.
+func (c *Consumer) dial(ctx context.Context, queueName string) (*amqp.Connection, <-chan amqp.Delivery, error) {
 	connection, err := amqp.Dial(config.CurrentConfig.RabbitMQ.Url)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	defer connection.Close()
 
 	channel, err := connection.Channel()
 	if err != nil {
-		return err
+		connection.Close()
+		return nil, nil, err
+	}
+
+	if err := channel.Qos(c.cfg.Prefetch, 0, false); err != nil {
+		connection.Close()
+		return nil, nil, err
 	}
-	defer channel.Close()
 
 	queue, err := channel.QueueDeclare(queueName, false, false, false, false, nil)
 	if err != nil {
-		return err
+		connection.Close()
+		return nil, nil, err
 	}
 
 	messages, err := channel.ConsumeWithContext(ctx, queue.Name, "", false, false, true, false, nil)
 	if err != nil {
-		return err
+		connection.Close()
+		return nil, nil, err
 	}
+	return connection, messages, nil
+}
 
-	go func() {
-		for {
-			select {
-			case msg, ok := <-messages:
-				if !ok {
-					fmt.Println("Channel closed")
-					return
-				}
-				if err := c.processMessage(msg); err != nil {
-					fmt.Println("error:", err)
-					if err := msg.Nack(false, true); err != nil {
-						return
-					}
-				} else {
-					if err := msg.Ack(false); err != nil {
-						return
-					}
+// forward hands messages to the workers until ctx is cancelled, in which case
+// it returns nil, or the connection is lost, in which case it returns the
+// reason.
+func forward(ctx context.Context, messages <-chan amqp.Delivery, closed <-chan *amqp.Error, deliveries chan<- amqp.Delivery) error {
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
 				}
+				return errors.New("delivery channel closed")
+			}
+			select {
+			case deliveries <- msg:
 			case <-ctx.Done():
+				return nil
+			}
+		case amqpErr := <-closed:
+			if amqpErr == nil {
+				return errors.New("connection closed")
+			}
+			return amqpErr
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// ReconnectBackoff returns how long to wait before the next connection
+// attempt: 1s doubling up to 30s, with up to half of it taken off at random
+// so that workers do not reconnect in lockstep.
+func ReconnectBackoff(attempt int) time.Duration {
+	delay := 30 * time.Second
+	if attempt < 5 {
+		delay = time.Second << attempt
+	}
+	return delay - rand.N(delay/2+1)
+}
+
+func (c *Consumer) setConnected(connected bool) {
+	c.connected.Store(connected)
+	if connected {
+		c.metrics.Connected.Set(1)
+	} else {
+		c.metrics.Connected.Set(0)
+	}
+}
+
+// Connected reports whether the consumer currently holds a broker
+// connection.
+func (c *Consumer) Connected() bool {
+	return c.connected.Load()
+}
+
+// runWorker handles deliveries one at a time until ctx is cancelled or the
+// delivery channel is closed.
+func (c *Consumer) runWorker(ctx context.Context, worker string, messages <-chan amqp.Delivery) {
+	busy := c.metrics.Busy.WithLabelValues(worker)
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
 				return
 			}
+			busy.Set(1)
+			start := time.Now()
+			outcome := c.handle(msg)
+			busy.Set(0)
+			c.metrics.Duration.WithLabelValues(worker).Observe(time.Since(start).Seconds())
+			c.metrics.Tasks.WithLabelValues(worker, outcome).Inc()
+		case <-ctx.Done():
+			return
 		}
-	}()
+	}
+}
 
-	log.Printf(" [*] Waiting for messages. To exit press CTRL+C")
-	<-ctx.Done()
-	return nil
+// handle processes a delivery and settles it: malformed messages are
+// dropped, and messages that failed for a reason other than the task itself
+// are requeued. It returns the outcome for metrics.
+func (c *Consumer) handle(msg amqp.Delivery) string {
+	err := c.processMessage(msg)
+	var outcome string
+	var settleErr error
+	switch {
+	case err == nil:
+		outcome = "acked"
+		settleErr = msg.Ack(false)
+	case errors.Is(err, errMalformedMessage):
+		service.LogError("drop message: %v", err)
+		outcome = "rejected"
+		settleErr = msg.Reject(false)
+	default:
+		service.LogError("requeue message: %v", err)
+		outcome = "requeued"
+		settleErr = msg.Nack(false, true)
+	}
+	if settleErr != nil {
+		service.LogError("settle message: %v", settleErr)
+	}
+	return outcome
 }
 
 func (c *Consumer) processMessage(data amqp.Delivery) error {
 	var audio types.AudioMessage
 	if err := json.Unmarshal(data.Body, &audio); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedMessage, err)
+	}
+
+	// Register before starting so a cancel published right after StartTask
+	// is not missed.
+	ctx, done := c.track(audio.TaskID)
+	defer done()
+
+	started, err := c.store.StartTask(audio.TaskID)
+	if err != nil {
+		return err
+	}
+	if !started {
+		service.LogInfo("task %s is no longer queued, skipping", audio.TaskID)
+		return nil
+	}
+	c.publishEvent(audio.TaskID)
+	// Heartbeat from the start so slow source checks or cache lookups are
+	// not mistaken for a dead worker.
+	stop := c.heartbeat(audio.TaskID)
+	defer stop()
+
+	// The host was checked on submission, but it may resolve differently
+	// now, so check again right before the provider fetches it.
+	if err := c.sources.Check(ctx, audio.Audio); err != nil {
+		if ctx.Err() != nil {
+			if errors.Is(context.Cause(ctx), errDrainTimeout) {
+				return c.release(audio.TaskID)
+			}
+			return nil
+		}
+		service.LogError("source of task %s: %v", audio.TaskID, err)
+		if errors.Is(err, urlpolicy.ErrBlocked) {
+			return c.fail(audio.TaskID, types.ErrorSourceBlocked, err.Error())
+		}
+		return c.fail(audio.TaskID, types.ErrorSourceUnreachable, err.Error())
+	}
+
+	cached, err := c.fromCache(ctx, audio)
+	if err != nil {
+		return err
+	}
+	if cached {
+		service.LogInfo("task %s served from cache", audio.TaskID)
+		c.publishEvent(audio.TaskID)
+		return nil
+	}
+
+	// Deepgram fetches the audio itself, so the task is transcribing as soon
+	// as the request is sent.
+	if ok, err := c.advance(audio.TaskID, types.StatusTranscribing); !ok {
 		return err
 	}
-	text, err := ConvertToText(audio.Audio)
+	transcript, err := ConvertToText(ctx, audio.Audio, audio.TranscriptionOptions)
 	if err != nil {
-		_ = c.store.UpdateTaskFailed(audio.TaskID)
+		if ctx.Err() != nil {
+			if errors.Is(context.Cause(ctx), errDrainTimeout) {
+				return c.release(audio.TaskID)
+			}
+			service.LogInfo("task %s cancelled during transcription", audio.TaskID)
+			return nil
+		}
+		service.LogError("transcribe task %s: %v", audio.TaskID, err)
+		taskErr := ClassifyError(err)
+		return c.fail(audio.TaskID, taskErr.Code, taskErr.Message)
+	}
+
+	if ok, err := c.advance(audio.TaskID, types.StatusPostProcessing); !ok {
 		return err
 	}
-	if err := c.store.AddResultTask(audio.TaskID, text); err != nil {
-		_ = c.store.UpdateTaskFailed(audio.TaskID)
+	if err := c.store.AddResultTask(audio.TaskID, transcript); err != nil {
+		service.LogError("store result of task %s: %v", audio.TaskID, err)
+		return c.fail(audio.TaskID, types.ErrorInternal, err.Error())
+	}
+	c.publishEvent(audio.TaskID)
+	return nil
+}
+
+// fail records the task as failed. The message is settled once the failure
+// is stored, since the task is then finished and needs no redelivery.
+func (c *Consumer) fail(taskID string, code string, message string) error {
+	if err := c.store.UpdateTaskFailed(taskID, code, message); err != nil {
 		return err
 	}
+	c.publishEvent(taskID)
 	return nil
 }
+
+// release puts a task abandoned on shutdown back to queued and returns
+// errDrainTimeout so its message is requeued.
+func (c *Consumer) release(taskID string) error {
+	if _, err := c.store.ReleaseTask(taskID); err != nil {
+		return err
+	}
+	c.publishEvent(taskID)
+	return errDrainTimeout
+}
+
+// advance moves the task to the next stage and publishes the change. It
+// returns false if the task can no longer move there, e.g. because it was
+// cancelled, and processing should stop.
+func (c *Consumer) advance(taskID string, status types.TaskStatus) (bool, error) {
+	ok, err := c.store.AdvanceTask(taskID, status)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		service.LogInfo("task %s cannot move to %s, skipping", taskID, status)
+		return false, nil
+	}
+	c.publishEvent(taskID)
+	return true, nil
+}
+
+func (c *Consumer) publishEvent(taskID string) {
+	if err := PublishTaskEvent(c.store, c.pubsub, taskID); err != nil {
+		service.LogError("publish event for task %s: %v", taskID, err)
+	}
+}
+
+// track registers a cancellable context for an in-flight task. The returned
+// function releases it.
+func (c *Consumer) track(taskID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(c.base)
+	c.mu.Lock()
+	c.inflight[taskID] = cancel
+	c.mu.Unlock()
+	return ctx, func() {
+		c.mu.Lock()
+		delete(c.inflight, taskID)
+		c.mu.Unlock()
+		cancel()
+	}
+}
+
+// watchCancellations aborts in-flight tasks cancelled through the API.
+func (c *Consumer) watchCancellations(ctx context.Context) {
+	for taskID := range c.pubsub.SubscribeCancel(ctx) {
+		c.mu.Lock()
+		cancel, ok := c.inflight[taskID]
+		c.mu.Unlock()
+		if ok {
+			cancel()
+		}
+	}
+}
+
+// heartbeat periodically touches the task so the reaper sees it is still
+// being worked on. The returned function stops it.
+func (c *Consumer) heartbeat(taskID string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(config.CurrentConfig.Reaper.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := c.store.HeartbeatTask(taskID); err != nil {
+					service.LogError("heartbeat task %s: %v", taskID, err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
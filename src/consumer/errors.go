@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"speechToText/src/types"
+	"strings"
+
+	interfaces "github.com/deepgram/deepgram-go-sdk/pkg/client/interfaces"
+)
+
+// TaskError is a task failure with a machine-readable code.
+type TaskError struct {
+	Code    string
+	Message string
+}
+
+func (e *TaskError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// ClassifyError maps an error from transcription to a TaskError. Errors that
+// are already TaskErrors are returned unchanged.
+func ClassifyError(err error) *TaskError {
+	var taskErr *TaskError
+	if errors.As(err, &taskErr) {
+		return taskErr
+	}
+	message := err.Error()
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &TaskError{Code: types.ErrorProviderTimeout, Message: message}
+	}
+
+	var statusErr *interfaces.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Resp == nil {
+		return &TaskError{Code: types.ErrorInternal, Message: message}
+	}
+	detail := ""
+	if statusErr.DeepgramError != nil {
+		detail = strings.ToLower(statusErr.DeepgramError.ErrCode + " " + statusErr.DeepgramError.ErrMsg)
+	}
+
+	switch code := statusErr.Resp.StatusCode; {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden || code == http.StatusPaymentRequired:
+		return &TaskError{Code: types.ErrorProviderAuth, Message: message}
+	case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+		return &TaskError{Code: types.ErrorProviderTimeout, Message: message}
+	case code == http.StatusBadRequest && strings.Contains(detail, "remote_content"):
+		return &TaskError{Code: types.ErrorSourceUnreachable, Message: message}
+	case code == http.StatusBadRequest && (strings.Contains(detail, "unsupported") || strings.Contains(detail, "corrupt")):
+		return &TaskError{Code: types.ErrorUnsupportedFormat, Message: message}
+	case code >= 400 && code < 500 && code != http.StatusTooManyRequests:
+		return &TaskError{Code: types.ErrorProviderRejected, Message: message}
+	default:
+		return &TaskError{Code: types.ErrorProviderError, Message: message}
+	}
+}
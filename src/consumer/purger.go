@@ -0,0 +1,63 @@
+package consumer
+
+import (
+	"context"
+	"speechToText/src/config"
+	"speechToText/src/db"
+	"speechToText/src/service"
+	"time"
+)
+
+// Purger erases expired audio URLs and deletes expired tasks according to
+// each user's retention settings.
+type Purger struct {
+	store     *db.Store
+	interval  time.Duration
+	batchSize int
+}
+
+func NewPurger(store *db.Store, cfg *config.RetentionConfig) *Purger {
+	return &Purger{
+		store:     store,
+		interval:  cfg.Interval,
+		batchSize: cfg.BatchSize,
+	}
+}
+
+// Run purges expired data every interval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			audio, err := p.drain(ctx, p.store.PurgeExpiredAudio)
+			if err != nil {
+				service.LogError("purger: audio: %v", err)
+			}
+			tasks, err := p.drain(ctx, p.store.PurgeExpiredTasks)
+			if err != nil {
+				service.LogError("purger: tasks: %v", err)
+			}
+			if audio > 0 || tasks > 0 {
+				service.LogInfo("purger: erased audio of %d tasks, deleted %d tasks", audio, tasks)
+			}
+		}
+	}
+}
+
+// drain runs purge in batches until a batch comes back short.
+func (p *Purger) drain(ctx context.Context, purge func(limit int) (int64, error)) (int64, error) {
+	var total int64
+	for ctx.Err() == nil {
+		n, err := purge(p.batchSize)
+		total += n
+		if err != nil || n < int64(p.batchSize) {
+			return total, err
+		}
+	}
+	return total, nil
+}
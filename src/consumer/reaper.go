@@ -0,0 +1,62 @@
+package consumer
+
+import (
+	"context"
+	"speechToText/src/cache"
+	"speechToText/src/config"
+	"speechToText/src/db"
+	"speechToText/src/service"
+	"time"
+)
+
+// Reaper requeues or fails tasks whose worker stopped sending heartbeats and
+// republishes messages of queued tasks that were lost.
+type Reaper struct {
+	store          *db.Store
+	pubsub         *cache.PubSub
+	interval       time.Duration
+	timeout        time.Duration
+	maxRequeues    int
+	republishAfter time.Duration
+}
+
+func NewReaper(store *db.Store, pubsub *cache.PubSub, cfg *config.ReaperConfig) *Reaper {
+	return &Reaper{
+		store:          store,
+		pubsub:         pubsub,
+		interval:       cfg.Interval,
+		timeout:        cfg.Timeout,
+		maxRequeues:    cfg.MaxRequeues,
+		republishAfter: cfg.RepublishAfter,
+	}
+}
+
+// Run scans for stuck tasks every interval until ctx is cancelled.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := r.store.ReapStuckTasks(r.timeout, r.maxRequeues, r.republishAfter, TaskQueue)
+			if err != nil {
+				service.LogError("reaper: %v", err)
+				continue
+			}
+			if len(result.Requeued) > 0 || len(result.Failed) > 0 {
+				service.LogInfo("reaper: requeued %d, failed %d stuck tasks", len(result.Requeued), len(result.Failed))
+			}
+			if len(result.Republished) > 0 {
+				service.LogInfo("reaper: republished messages of %d queued tasks", len(result.Republished))
+			}
+			for _, taskID := range append(result.Requeued, result.Failed...) {
+				if err := PublishTaskEvent(r.store, r.pubsub, taskID); err != nil {
+					service.LogError("publish event for task %s: %v", taskID, err)
+				}
+			}
+		}
+	}
+}
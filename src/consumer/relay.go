@@ -0,0 +1,69 @@
+package consumer
+
+import (
+	"context"
+	"speechToText/src/config"
+	"speechToText/src/db"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"time"
+)
+
+// Relay publishes pending outbox messages to RabbitMQ.
+type Relay struct {
+	store     *db.Store
+	producer  *Producer
+	interval  time.Duration
+	batchSize int
+	retention time.Duration
+}
+
+func NewRelay(store *db.Store, producer *Producer, cfg *config.OutboxConfig) *Relay {
+	return &Relay{
+		store:     store,
+		producer:  producer,
+		interval:  cfg.PollInterval,
+		batchSize: cfg.BatchSize,
+		retention: cfg.Retention,
+	}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+
+	for {
+		r.flush()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-purge.C:
+			if err := r.store.PurgeSentOutbox(r.retention); err != nil {
+				service.LogError("outbox purge: %v", err)
+			}
+		}
+	}
+}
+
+func (r *Relay) flush() {
+	for {
+		sent, err := r.store.ProcessOutbox(r.batchSize, func(msg types.OutboxMessage) error {
+			if err := r.producer.Publish(msg.Queue, msg.Payload); err != nil {
+				service.LogError("outbox publish task %s (attempt %d): %v", msg.TaskID, msg.Attempts+1, err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			service.LogError("outbox relay: %v", err)
+			return
+		}
+		if sent < r.batchSize {
+			return
+		}
+	}
+}
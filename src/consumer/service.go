@@ -2,36 +2,100 @@ package consumer
 
 import (
 	"context"
-	"fmt"
+	"speechToText/src/cache"
 	"speechToText/src/config"
 	"speechToText/src/db"
 	"speechToText/src/service"
 	"speechToText/src/types"
+	"strings"
 
 	"github.com/google/uuid"
 
 	listen "github.com/deepgram/deepgram-go-sdk/pkg/api/listen/v1/rest"
+	api "github.com/deepgram/deepgram-go-sdk/pkg/api/listen/v1/rest/interfaces"
 	interfaces "github.com/deepgram/deepgram-go-sdk/pkg/client/interfaces"
 	client "github.com/deepgram/deepgram-go-sdk/pkg/client/listen"
 )
 
-func CreateTask(store *db.Store, producer *Producer, username string, request types.AudioRequest) (string, error) {
+// TaskQueue is the RabbitMQ queue transcription tasks are published to.
+const TaskQueue = "queue"
+
+// CreateTask stores the task and its queue message in the outbox; the Relay
+// publishes it to RabbitMQ, so creation succeeds even if the broker is down.
+func CreateTask(store *db.Store, username string, request types.AudioRequest) (string, error) {
 	taskID := uuid.New().String()
-	if err := store.AddAudioTask(taskID, username, request.Audio); err != nil {
-		return "", err
-	}
-	if err := producer.Send(taskID, "queue", request.Audio); err != nil {
+	if err := store.AddAudioTask(taskID, username, request, TaskQueue); err != nil {
 		return "", err
 	}
 	return taskID, nil
 }
 
-func ConvertToText(audioUrl string) (string, error) {
-	ctx := context.Background()
+// CreateBatch stores a batch and one task per item, filling empty item fields
+// from the batch defaults.
+func CreateBatch(store *db.Store, username string, request types.BatchRequest) (string, []string, error) {
+	batchID := uuid.New().String()
+	items := make([]types.AudioRequest, len(request.Items))
+	taskIDs := make([]string, len(request.Items))
+	for i, item := range request.Items {
+		items[i] = MergeAudioRequest(item, request.Defaults)
+		taskIDs[i] = uuid.New().String()
+	}
+	if err := store.AddBatch(batchID, username, items, taskIDs, TaskQueue); err != nil {
+		return "", nil, err
+	}
+	return batchID, taskIDs, nil
+}
+
+// MergeAudioRequest fills the empty fields of item from defaults.
+func MergeAudioRequest(item types.AudioRequest, defaults types.AudioRequest) types.AudioRequest {
+	if item.CallbackURL == "" {
+		item.CallbackURL = defaults.CallbackURL
+	}
+	if item.Model == "" {
+		item.Model = defaults.Model
+	}
+	if item.Language == "" {
+		item.Language = defaults.Language
+	}
+	if len(item.Tags) == 0 {
+		item.Tags = defaults.Tags
+	}
+	item.NoCache = item.NoCache || defaults.NoCache
+	return item
+}
+
+// PublishTaskEvent pushes the task's current status to SSE subscribers.
+func PublishTaskEvent(store *db.Store, pubsub *cache.PubSub, taskID string) error {
+	event, err := store.GetTaskEvent(taskID)
+	if err != nil {
+		return err
+	}
+	return pubsub.PublishTaskEvent(context.Background(), event)
+}
+
+// Options used when a task does not set its own.
+const (
+	defaultModel    = "nova-2"
+	defaultLanguage = "en"
+)
+
+// Subtitle segments end after a sentence, or once they reach these limits.
+const (
+	maxSegmentDuration = 7.0
+	maxSegmentWords    = 14
+)
+
+func ConvertToText(ctx context.Context, audioUrl string, opts types.TranscriptionOptions) (types.Transcript, error) {
 	service.LogDebug("AUDIO URL: %s", audioUrl)
 	options := &interfaces.PreRecordedTranscriptionOptions{
-		Model:    "nova-2",
-		Language: "en",
+		Model:    defaultModel,
+		Language: defaultLanguage,
+	}
+	if opts.Model != "" {
+		options.Model = opts.Model
+	}
+	if opts.Language != "" {
+		options.Language = opts.Language
 	}
 
 	c := client.NewREST(config.CurrentConfig.Deepgram.ApiKey, &interfaces.ClientOptions{})
@@ -40,12 +104,49 @@ func ConvertToText(audioUrl string) (string, error) {
 	res, err := dg.FromURL(ctx, audioUrl, options)
 	if err != nil {
 		service.LogError("FromURL failed. Err: %v", err)
-		return "", err
+		return types.Transcript{}, err
 	}
 
-	if len(res.Results.Channels) == 0 || len(res.Results.Channels[0].Alternatives) == 0 {
-		return "", fmt.Errorf("no transcription result returned by Deepgram")
+	if res.Results == nil || len(res.Results.Channels) == 0 || len(res.Results.Channels[0].Alternatives) == 0 {
+		return types.Transcript{}, &TaskError{Code: types.ErrorEmptyResult, Message: "no transcription result returned by Deepgram"}
 	}
 
-	return res.Results.Channels[0].Alternatives[0].Transcript, nil
+	alternative := res.Results.Channels[0].Alternatives[0]
+	if strings.TrimSpace(alternative.Transcript) == "" {
+		return types.Transcript{}, &TaskError{Code: types.ErrorEmptyResult, Message: "no speech recognized in audio"}
+	}
+	transcript := types.Transcript{Text: alternative.Transcript, Segments: segmentWords(alternative.Words)}
+	if res.Metadata != nil {
+		transcript.Duration = res.Metadata.Duration
+	}
+	return transcript, nil
+}
+
+// segmentWords groups timed words into subtitle segments.
+func segmentWords(words []api.Word) []types.Segment {
+	var segments []types.Segment
+	var current []string
+	var start, end float64
+	for _, word := range words {
+		text := word.PunctuatedWord
+		if text == "" {
+			text = word.Word
+		}
+		if text == "" {
+			continue
+		}
+		if len(current) == 0 {
+			start = word.Start
+		}
+		current = append(current, text)
+		end = word.End
+		if strings.HasSuffix(text, ".") || strings.HasSuffix(text, "?") || strings.HasSuffix(text, "!") || end-start >= maxSegmentDuration || len(current) >= maxSegmentWords {
+			segments = append(segments, types.Segment{Start: start, End: end, Text: strings.Join(current, " ")})
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		segments = append(segments, types.Segment{Start: start, End: end, Text: strings.Join(current, " ")})
+	}
+	return segments
 }
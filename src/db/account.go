@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"speechToText/src/types"
+
+	"github.com/google/uuid"
+)
+
+// GetProfile returns the user with a summary of their usage. It returns
+// sql.ErrNoRows if there is no such user.
+func (s *Store) GetProfile(username string) (types.ProfileResponse, error) {
+	profile := types.ProfileResponse{
+		Username: username,
+		Usage:    types.UsageSummary{ByStatus: map[types.TaskStatus]int64{}},
+	}
+	var createdAt, lastTaskAt sql.NullTime
+	var audioSeconds sql.NullFloat64
+	err := s.db.QueryRow(`
+		SELECT u.role, u.created_at,
+			(SELECT COUNT(*) FROM tasks WHERE username = u.username AND created_at >= NOW() - INTERVAL '30 days'),
+			(SELECT SUM(duration) FROM tasks WHERE username = u.username AND status = 'completed'),
+			(SELECT MAX(created_at) FROM tasks WHERE username = u.username),
+			(SELECT COUNT(*) FROM api_keys WHERE username = u.username AND revoked_at IS NULL),
+			(SELECT COUNT(*) FROM webhooks WHERE username = u.username),
+			(SELECT COUNT(*) FROM exports WHERE username = u.username)
+		FROM users u WHERE u.username = $1`,
+		username,
+	).Scan(
+		&profile.Role, &createdAt, &profile.Usage.TasksLast30d, &audioSeconds, &lastTaskAt,
+		&profile.Usage.ActiveAPIKeys, &profile.Usage.Webhooks, &profile.Usage.StoredExports,
+	)
+	if err != nil {
+		return profile, err
+	}
+	profile.Created = formatTime(createdAt)
+	profile.Usage.AudioSeconds = audioSeconds.Float64
+	profile.Usage.LastTaskAt = formatTime(lastTaskAt)
+
+	for _, status := range types.TaskStatuses {
+		profile.Usage.ByStatus[status] = 0
+	}
+	rows, err := s.db.Query("SELECT status, COUNT(*) FROM tasks WHERE username = $1 GROUP BY status", username)
+	if err != nil {
+		return profile, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status types.TaskStatus
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return profile, err
+		}
+		profile.Usage.ByStatus[status] = count
+		profile.Usage.Tasks += count
+	}
+	return profile, rows.Err()
+}
+
+// ChangePassword stores the new password hash, starts a new session version
+// and revokes every refresh token family of the user but keepFamily. It
+// returns the new session version.
+func (s *Store) ChangePassword(username string, passwordHash string, keepFamily string) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	version := uuid.New().String()
+	result, err := tx.Exec(
+		"UPDATE users SET password = $2, session_version = $3 WHERE username = $1",
+		username, passwordHash, version,
+	)
+	if err != nil {
+		return "", err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if rows == 0 {
+		return "", sql.ErrNoRows
+	}
+	if _, err := tx.Exec(
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE username = $1 AND family_id <> $2 AND revoked_at IS NULL",
+		username, keepFamily,
+	); err != nil {
+		return "", err
+	}
+	return version, tx.Commit()
+}
+
+// DeleteAccount deletes the user with their tasks and everything recorded
+// about them, in one transaction. It returns the user's exports, whose
+// archives the caller removes from storage, and the tasks that were still
+// unfinished, whose workers the caller must cancel. It returns
+// sql.ErrNoRows if there is no such user.
+func (s *Store) DeleteAccount(username string) ([]types.ExportInfo, []string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow("SELECT username FROM users WHERE username = $1 FOR UPDATE", username).Scan(&username); err != nil {
+		return nil, nil, err
+	}
+	rows, err := tx.Query("SELECT id, format FROM exports WHERE username = $1", username)
+	if err != nil {
+		return nil, nil, err
+	}
+	var exports []types.ExportInfo
+	for rows.Next() {
+		var info types.ExportInfo
+		if err := rows.Scan(&info.ExportID, &info.Format); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		exports = append(exports, info)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.Query(
+		"SELECT task_id FROM tasks WHERE username = $1 AND status = ANY($2) FOR UPDATE",
+		username, statusArray(types.UnfinishedStatuses()),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unfinished []string
+	for rows.Next() {
+		var taskID string
+		if err := rows.Scan(&taskID); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		unfinished = append(unfinished, taskID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// Tables keyed by username without a foreign key to users; the rest go
+	// with the user by cascade.
+	for _, query := range []string{
+		"DELETE FROM outbox WHERE sent_at IS NULL AND task_id IN (SELECT task_id FROM tasks WHERE username = $1)",
+		"DELETE FROM tasks WHERE username = $1",
+		"DELETE FROM batches WHERE username = $1",
+		"DELETE FROM webhook_deliveries WHERE username = $1",
+		"DELETE FROM purge_log WHERE username = $1",
+		"DELETE FROM users WHERE username = $1",
+	} {
+		if _, err := tx.Exec(query, username); err != nil {
+			return nil, nil, err
+		}
+	}
+	return exports, unfinished, tx.Commit()
+}
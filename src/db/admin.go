@@ -0,0 +1,339 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// insertAudit records an admin action or security event. Actions that change data write their
+// entry in the same transaction, so the log cannot miss a change.
+func insertAudit(e execer, actor string, action string, target string, details map[string]any) error {
+	var payload sql.NullString
+	if len(details) > 0 {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		payload = sql.NullString{String: string(data), Valid: true}
+	}
+	_, err := e.Exec(
+		"INSERT INTO audit_log (actor, action, target, details) VALUES ($1, $2, $3, $4)",
+		actor, action, target, payload,
+	)
+	return err
+}
+
+// AddAuditEntry records an event outside a transaction, such as a login
+// lockout.
+func (s *Store) AddAuditEntry(actor string, action string, target string, details map[string]any) error {
+	return insertAudit(s.db, actor, action, target, details)
+}
+
+// ListAuditLog returns up to limit entries, newest first, optionally only
+// those of one actor, and records that viewer read them.
+func (s *Store) ListAuditLog(viewer string, actor string, limit int, offset int) ([]types.AuditEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT id, actor, action, target, details, created_at
+		FROM audit_log
+		WHERE $1 = '' OR actor = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`,
+		actor, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []types.AuditEntry{}
+	for rows.Next() {
+		var entry types.AuditEntry
+		var details []byte
+		var createdAt time.Time
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.Target, &details, &createdAt); err != nil {
+			return nil, err
+		}
+		if details != nil {
+			if err := json.Unmarshal(details, &entry.Details); err != nil {
+				return nil, err
+			}
+		}
+		entry.Created = createdAt.Format(time.RFC3339)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, insertAudit(s.db, viewer, types.AuditViewLog, actor, nil)
+}
+
+// GetUserAccess returns the user's role, whether the account is disabled and
+// its session version. It returns sql.ErrNoRows if there is no such user.
+func (s *Store) GetUserAccess(username string) (types.UserAccess, error) {
+	var access types.UserAccess
+	err := s.db.QueryRow(
+		"SELECT role, disabled_at IS NOT NULL, session_version FROM users WHERE username = $1",
+		username,
+	).Scan(&access.Role, &access.Disabled, &access.SessionVersion)
+	return access, err
+}
+
+// ListUsers returns the users matching the query ordered by username, with
+// how many tasks each has, and records the search.
+func (s *Store) ListUsers(actor string, query types.UserQuery) ([]types.UserInfo, error) {
+	search := ""
+	if query.Search != "" {
+		search = "%" + likeEscaper.Replace(query.Search) + "%"
+	}
+	rows, err := s.db.Query(`
+		SELECT u.username, u.role, u.created_at, u.disabled_at,
+			(SELECT COUNT(*) FROM tasks t WHERE t.username = u.username)
+		FROM users u
+		WHERE ($1 = '' OR u.username ILIKE $1 ESCAPE '\')
+		  AND ($2 = '' OR u.role = $2)
+		ORDER BY u.username
+		LIMIT $3 OFFSET $4`,
+		search, query.Role, query.Limit, query.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []types.UserInfo{}
+	for rows.Next() {
+		var user types.UserInfo
+		var createdAt, disabledAt sql.NullTime
+		if err := rows.Scan(&user.Username, &user.Role, &createdAt, &disabledAt, &user.Tasks); err != nil {
+			return nil, err
+		}
+		user.Created = formatTime(createdAt)
+		user.DisabledAt = formatTime(disabledAt)
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, insertAudit(s.db, actor, types.AuditListUsers, "", map[string]any{
+		"search": query.Search,
+		"role":   query.Role,
+	})
+}
+
+// SetUserDisabled disables or re-enables the account. Disabling revokes the
+// user's refresh tokens; sessions and API keys are refused from the next
+// request. It returns false if there is no such user.
+func (s *Store) SetUserDisabled(actor string, username string, disabled bool) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	action, query := types.AuditEnableUser, "UPDATE users SET disabled_at = NULL WHERE username = $1"
+	if disabled {
+		action, query = types.AuditDisableUser, "UPDATE users SET disabled_at = COALESCE(disabled_at, NOW()) WHERE username = $1"
+	}
+	result, err := tx.Exec(query, username)
+	if err != nil {
+		return false, err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return false, err
+	}
+	if disabled {
+		if _, err := tx.Exec(
+			"UPDATE refresh_tokens SET revoked_at = NOW() WHERE username = $1 AND revoked_at IS NULL",
+			username,
+		); err != nil {
+			return false, err
+		}
+	}
+	if err := insertAudit(tx, actor, action, username, nil); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// SetUserRole changes the user's role. It returns false if there is no such
+// user.
+func (s *Store) SetUserRole(actor string, username string, role string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow("SELECT role FROM users WHERE username = $1 FOR UPDATE", username).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE users SET role = $2 WHERE username = $1", username, role); err != nil {
+		return false, err
+	}
+	if err := insertAudit(tx, actor, types.AuditSetRole, username, map[string]any{
+		"from": previous,
+		"to":   role,
+	}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetAdminTask returns any user's task and records that actor viewed it. It
+// returns sql.ErrNoRows if there is no such task.
+func (s *Store) GetAdminTask(actor string, taskID string) (types.AdminTask, error) {
+	task := types.AdminTask{TaskInfo: types.TaskInfo{TaskID: taskID}}
+	var language, model, errorCode, errorMessage, result sql.NullString
+	var tags pq.StringArray
+	var createdAt, queuedAt, startedAt, finishedAt, heartbeatAt, audioExpiresAt, expiresAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT username, status, audio, model, language, no_cache, tags, error_code, error_message, result,
+			attempt, requeues, created_at, queued_at, started_at, finished_at, heartbeat_at,
+			audio_expires_at, expires_at, COALESCE(cached_from, '')
+		FROM tasks WHERE task_id = $1`,
+		taskID,
+	).Scan(
+		&task.Username, &task.Status, &task.Audio, &model, &language, &task.NoCache, &tags,
+		&errorCode, &errorMessage, &result, &task.Attempt, &task.Requeues,
+		&createdAt, &queuedAt, &startedAt, &finishedAt, &heartbeatAt,
+		&audioExpiresAt, &expiresAt, &task.CachedFrom,
+	)
+	if err != nil {
+		return task, err
+	}
+	task.Model = model.String
+	task.Language = language.String
+	task.Tags = append([]string{}, tags...)
+	task.ErrorCode = errorCode.String
+	task.Error = errorMessage.String
+	task.Result = result.String
+	task.Created = formatTime(createdAt)
+	task.QueuedAt = formatTime(queuedAt)
+	task.StartedAt = formatTime(startedAt)
+	task.FinishedAt = formatTime(finishedAt)
+	task.HeartbeatAt = formatTime(heartbeatAt)
+	task.AudioExpiresAt = formatTime(audioExpiresAt)
+	task.ExpiresAt = formatTime(expiresAt)
+	return task, insertAudit(s.db, actor, types.AuditViewTask, taskID, map[string]any{"username": task.Username})
+}
+
+// RequeueTask puts any user's unfinished or failed task back on the queue:
+// a queued task gets another queue message, an active one with no heartbeat
+// for longer than staleAfter goes back to queued as the reaper would do, and
+// a failed one is retried. It returns false if the task does not exist, is
+// still being worked on, or has completed or been cancelled.
+func (s *Store) RequeueTask(actor string, taskID string, staleAfter time.Duration, queueName string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	message := types.AudioMessage{TaskID: taskID}
+	var username string
+	var status types.TaskStatus
+	var model, language sql.NullString
+	var stale bool
+	err = tx.QueryRow(`
+		SELECT username, status, audio, model, language, no_cache,
+			COALESCE(heartbeat_at, started_at, queued_at) < NOW() - $2 * INTERVAL '1 millisecond'
+		FROM tasks WHERE task_id = $1
+		FOR UPDATE`,
+		taskID, staleAfter.Milliseconds(),
+	).Scan(&username, &status, &message.Audio, &model, &language, &message.NoCache, &stale)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	message.Model = model.String
+	message.Language = language.String
+
+	switch {
+	case status == types.StatusFailed:
+		retried, err := retryTask(tx, taskID, username, types.TranscriptionOptions{}, queueName)
+		if err != nil || !retried {
+			return false, err
+		}
+	case status == types.StatusQueued:
+		if err := enqueueTask(tx, message, queueName); err != nil {
+			return false, err
+		}
+	case status.IsActive():
+		// A worker that still heartbeats would go on and store its result
+		// next to the new attempt's.
+		if !stale {
+			return false, nil
+		}
+		requeued, err := requeueActiveTask(tx, message, queueName)
+		if err != nil || !requeued {
+			return false, err
+		}
+	default:
+		return false, nil
+	}
+	if err := insertAudit(tx, actor, types.AuditRequeueTask, taskID, map[string]any{
+		"username": username,
+		"status":   status,
+	}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// TaskStats returns system-wide task statistics and records that actor
+// viewed them.
+func (s *Store) TaskStats(actor string) (types.TaskStats, error) {
+	stats := types.TaskStats{ByStatus: map[types.TaskStatus]int64{}}
+	for _, status := range types.TaskStatuses {
+		stats.ByStatus[status] = 0
+	}
+	rows, err := s.db.Query("SELECT status, COUNT(*) FROM tasks GROUP BY status")
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status types.TaskStatus
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return stats, err
+		}
+		stats.ByStatus[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	var avg sql.NullFloat64
+	err = s.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM tasks WHERE created_at >= NOW() - INTERVAL '24 hours'),
+			(SELECT COUNT(*) FROM tasks WHERE status = 'completed' AND finished_at >= NOW() - INTERVAL '24 hours'),
+			(SELECT COUNT(*) FROM tasks WHERE status = 'failed' AND finished_at >= NOW() - INTERVAL '24 hours'),
+			(SELECT AVG(EXTRACT(EPOCH FROM finished_at - started_at)) FROM tasks
+				WHERE status = 'completed' AND finished_at >= NOW() - INTERVAL '24 hours' AND started_at IS NOT NULL),
+			(SELECT COUNT(*) FROM outbox WHERE sent_at IS NULL),
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL)`,
+	).Scan(
+		&stats.CreatedLast24h, &stats.CompletedLast24h, &stats.FailedLast24h, &avg,
+		&stats.PendingOutbox, &stats.Users, &stats.DisabledUsers,
+	)
+	if err != nil {
+		return stats, err
+	}
+	stats.AvgProcessingSeconds = avg.Float64
+	return stats, insertAudit(s.db, actor, types.AuditViewStats, "", nil)
+}
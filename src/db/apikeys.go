@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// CreateAPIKey stores a new key of the user. Only the hash of the key is
+// kept; the returned info carries no key.
+func (s *Store) CreateAPIKey(username string, name string, prefix string, keyHash string, scopes []string) (types.APIKeyInfo, error) {
+	key := types.APIKeyInfo{
+		ID:       uuid.New().String(),
+		Username: username,
+		Name:     name,
+		Prefix:   prefix,
+		Scopes:   scopes,
+	}
+	var createdAt time.Time
+	err := s.db.QueryRow(`
+		INSERT INTO api_keys (id, username, name, prefix, key_hash, scopes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`,
+		key.ID, username, name, prefix, keyHash, pq.Array(scopes),
+	).Scan(&createdAt)
+	key.Created = createdAt.Format(time.RFC3339)
+	return key, err
+}
+
+func (s *Store) ListAPIKeys(username string) ([]types.APIKeyInfo, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, prefix, scopes, created_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE username = $1
+		ORDER BY created_at, id`,
+		username,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []types.APIKeyInfo{}
+	for rows.Next() {
+		key := types.APIKeyInfo{Username: username}
+		var createdAt time.Time
+		var lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(
+			&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &createdAt, &lastUsedAt, &revokedAt,
+		); err != nil {
+			return nil, err
+		}
+		key.Created = createdAt.Format(time.RFC3339)
+		key.LastUsedAt = formatTime(lastUsedAt)
+		key.RevokedAt = formatTime(revokedAt)
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes the user's key. It returns false if the user has no
+// such active key.
+func (s *Store) RevokeAPIKey(id string, username string) (bool, error) {
+	result, err := s.db.Exec(
+		"UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND username = $2 AND revoked_at IS NULL",
+		id, username,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// UseAPIKey returns the active key with the given hash and its owner's role,
+// and records that it was used. last_used_at is only written once a minute
+// so that busy keys do not rewrite their row on every request. It returns
+// sql.ErrNoRows if there is no such key or its owner is disabled.
+func (s *Store) UseAPIKey(keyHash string) (key types.APIKeyInfo, role string, err error) {
+	var createdAt time.Time
+	var lastUsedAt sql.NullTime
+	err = s.db.QueryRow(`
+		UPDATE api_keys k SET last_used_at = NOW()
+		FROM users u
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL
+		  AND u.username = k.username AND u.disabled_at IS NULL
+		  AND (k.last_used_at IS NULL OR k.last_used_at < NOW() - INTERVAL '1 minute')
+		RETURNING k.id, k.username, k.name, k.prefix, k.scopes, k.created_at, k.last_used_at, u.role`,
+		keyHash,
+	).Scan(&key.ID, &key.Username, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &createdAt, &lastUsedAt, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either the key was used within the last minute or it is not valid.
+		err = s.db.QueryRow(`
+			SELECT k.id, k.username, k.name, k.prefix, k.scopes, k.created_at, k.last_used_at, u.role
+			FROM api_keys k JOIN users u ON u.username = k.username
+			WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND u.disabled_at IS NULL`,
+			keyHash,
+		).Scan(&key.ID, &key.Username, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &createdAt, &lastUsedAt, &role)
+	}
+	key.Created = createdAt.Format(time.RFC3339)
+	key.LastUsedAt = formatTime(lastUsedAt)
+	return key, role, err
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"speechToText/src/types"
+	"time"
+)
+
+// AddBatch creates the batch and one task per item in a single transaction.
+// taskIDs must have the same length as items.
+func (s *Store) AddBatch(batchID string, username string, items []types.AudioRequest, taskIDs []string, queueName string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"INSERT INTO batches (id, username, total) VALUES ($1, $2, $3)",
+		batchID, username, len(items),
+	); err != nil {
+		return err
+	}
+	for i, item := range items {
+		if err := insertTask(tx, taskIDs[i], username, batchID, item, queueName); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetBatch returns the batch with per-task status and counts per status.
+// It returns sql.ErrNoRows if the batch does not belong to the user.
+func (s *Store) GetBatch(batchID string, username string) (types.BatchStatusResponse, error) {
+	batch := types.BatchStatusResponse{BatchID: batchID, Counts: map[types.TaskStatus]int{}, Tasks: []types.BatchTask{}}
+	var createdAt time.Time
+	err := s.db.QueryRow(
+		"SELECT total, created_at FROM batches WHERE id = $1 AND username = $2",
+		batchID, username,
+	).Scan(&batch.Total, &createdAt)
+	if err != nil {
+		return batch, err
+	}
+	batch.Created = createdAt.Format(time.RFC3339)
+
+	rows, err := s.db.Query(
+		"SELECT task_id, audio, status FROM tasks WHERE batch_id = $1 ORDER BY created_at, task_id",
+		batchID,
+	)
+	if err != nil {
+		return batch, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var task types.BatchTask
+		if err := rows.Scan(&task.TaskID, &task.Audio, &task.Status); err != nil {
+			return batch, err
+		}
+		batch.Counts[task.Status]++
+		batch.Tasks = append(batch.Tasks, task)
+	}
+	batch.Done = true
+	for status, count := range batch.Counts {
+		if count > 0 && !status.IsFinal() {
+			batch.Done = false
+		}
+	}
+	return batch, rows.Err()
+}
+
+// ExportBatch returns the results of every task in the batch. It returns
+// sql.ErrNoRows if the batch does not belong to the user.
+func (s *Store) ExportBatch(batchID string, username string) ([]types.BatchExportItem, error) {
+	var exists bool
+	if err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM batches WHERE id = $1 AND username = $2)",
+		batchID, username,
+	).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	rows, err := s.db.Query(
+		"SELECT task_id, audio, status, result, error_code, error_message FROM tasks WHERE batch_id = $1 ORDER BY created_at, task_id",
+		batchID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []types.BatchExportItem{}
+	for rows.Next() {
+		var item types.BatchExportItem
+		var result, errorCode, errorMessage sql.NullString
+		if err := rows.Scan(&item.TaskID, &item.Audio, &item.Status, &result, &errorCode, &errorMessage); err != nil {
+			return nil, err
+		}
+		item.Result = result.String
+		item.ErrorCode = errorCode.String
+		item.Error = errorMessage.String
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
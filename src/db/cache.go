@@ -0,0 +1,32 @@
+package db
+
+import "speechToText/src/types"
+
+// SetTaskFingerprint records the fingerprint of the task's source, so later
+// tasks of the user with the same source can reuse its transcript.
+func (s *Store) SetTaskFingerprint(taskID string, fingerprint string) error {
+	_, err := s.db.Exec("UPDATE tasks SET fingerprint = $2 WHERE task_id = $1", taskID, fingerprint)
+	return err
+}
+
+// CompleteFromCache completes the task with the newest transcript of another
+// completed task of the same user with the same fingerprint, recording that
+// task in cached_from. It returns false if there is no such transcript or the
+// task can no longer complete.
+func (s *Store) CompleteFromCache(taskID string, fingerprint string) (bool, error) {
+	return s.transitionTask(taskID, types.StatusCompleted, `
+		UPDATE tasks t
+		SET status = $2, result = c.result, duration = c.duration, segments = c.segments,
+			cached_from = c.task_id, fingerprint = $4, finished_at = NOW()
+		FROM (
+			SELECT task_id, result, duration, segments
+			FROM tasks
+			WHERE username = (SELECT username FROM tasks WHERE task_id = $1)
+			  AND fingerprint = $4 AND status = 'completed' AND task_id <> $1 AND result IS NOT NULL
+			ORDER BY finished_at DESC
+			LIMIT 1
+		) c
+		WHERE t.task_id = $1 AND t.status = ANY($3)`,
+		fingerprint,
+	)
+}
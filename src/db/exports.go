@@ -0,0 +1,174 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"speechToText/src/types"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func (s *Store) CreateExport(exportID string, username string, request types.ExportRequest) error {
+	filter, err := json.Marshal(request.Filter)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO exports (id, username, format, files, filter) VALUES ($1, $2, $3, COALESCE($4::text[], '{}'), $5)",
+		exportID, username, request.Format, pq.Array(request.Files), filter,
+	)
+	return err
+}
+
+// GetExport returns the export with its owner in Username.
+func (s *Store) GetExport(exportID string) (types.ExportInfo, error) {
+	info := types.ExportInfo{ExportID: exportID}
+	var files pq.StringArray
+	var filter []byte
+	var errorMessage sql.NullString
+	var createdAt time.Time
+	var finishedAt, expiresAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT username, status, format, files, filter, task_count, size, error_message, created_at, finished_at, expires_at
+		FROM exports WHERE id = $1`,
+		exportID,
+	).Scan(
+		&info.Username, &info.Status, &info.Format, &files, &filter, &info.TaskCount, &info.Size,
+		&errorMessage, &createdAt, &finishedAt, &expiresAt,
+	)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(filter, &info.Filter); err != nil {
+		return info, err
+	}
+	info.Files = files
+	info.Error = errorMessage.String
+	info.Created = createdAt.Format(time.RFC3339)
+	info.FinishedAt = formatTime(finishedAt)
+	info.ExpiresAt = formatTime(expiresAt)
+	return info, nil
+}
+
+// ClaimExport marks the oldest pending export as running and returns it.
+// Exports left running for longer than stale, e.g. by a worker that died,
+// are claimed again. It returns nil if there is nothing to do.
+func (s *Store) ClaimExport(stale time.Duration) (*types.ExportJob, error) {
+	job := &types.ExportJob{}
+	var files pq.StringArray
+	var filter []byte
+	err := s.db.QueryRow(`
+		UPDATE exports SET status = $1, started_at = NOW()
+		WHERE id = (
+			SELECT id FROM exports
+			WHERE status = $2 OR (status = $1 AND started_at < NOW() - $3 * INTERVAL '1 millisecond')
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, username, format, files, filter`,
+		types.ExportRunning, types.ExportPending, stale.Milliseconds(),
+	).Scan(&job.ExportID, &job.Username, &job.Format, &files, &filter)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.Files = files
+	return job, json.Unmarshal(filter, &job.Filter)
+}
+
+func (s *Store) CompleteExport(exportID string, taskCount int, size int64, expiresAt time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE exports SET status = $2, task_count = $3, size = $4, finished_at = NOW(), expires_at = $5
+		WHERE id = $1`,
+		exportID, types.ExportCompleted, taskCount, size, expiresAt,
+	)
+	return err
+}
+
+func (s *Store) FailExport(exportID string, message string) error {
+	_, err := s.db.Exec(
+		"UPDATE exports SET status = $2, error_message = $3, finished_at = NOW() WHERE id = $1",
+		exportID, types.ExportFailed, message,
+	)
+	return err
+}
+
+// StreamExportTasks calls fn for every task of the user matching the
+// filter, oldest first, without holding more than one row in memory.
+func (s *Store) StreamExportTasks(username string, filter types.TaskFilter, fn func(types.ExportRow) error) error {
+	conditions, args := taskConditions(username, filter, nil)
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT task_id, language, tags, created_at, finished_at, duration, result, segments
+		FROM tasks
+		WHERE %s
+		ORDER BY created_at, task_id`,
+		strings.Join(conditions, " AND "),
+	), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row types.ExportRow
+		var language, result sql.NullString
+		var tags pq.StringArray
+		var createdAt time.Time
+		var finishedAt sql.NullTime
+		var duration sql.NullFloat64
+		var segments []byte
+		if err := rows.Scan(
+			&row.TaskID, &language, &tags, &createdAt, &finishedAt, &duration, &result, &segments,
+		); err != nil {
+			return err
+		}
+		if segments != nil {
+			if err := json.Unmarshal(segments, &row.Segments); err != nil {
+				return err
+			}
+		}
+		row.Language = language.String
+		row.Tags = append([]string{}, tags...)
+		row.Created = createdAt.Format(time.RFC3339)
+		row.FinishedAt = formatTime(finishedAt)
+		row.Duration = duration.Float64
+		row.Result = result.String
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExpiredExports returns up to limit exports whose download has expired.
+func (s *Store) ExpiredExports(limit int) ([]types.ExportInfo, error) {
+	rows, err := s.db.Query(
+		"SELECT id, format FROM exports WHERE expires_at <= NOW() ORDER BY expires_at LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []types.ExportInfo
+	for rows.Next() {
+		var info types.ExportInfo
+		if err := rows.Scan(&info.ExportID, &info.Format); err != nil {
+			return nil, err
+		}
+		exports = append(exports, info)
+	}
+	return exports, rows.Err()
+}
+
+func (s *Store) DeleteExport(exportID string) error {
+	_, err := s.db.Exec("DELETE FROM exports WHERE id = $1", exportID)
+	return err
+}
@@ -0,0 +1,2 @@
+ALTER TABLE task_attempts DROP COLUMN IF EXISTS error_code;
+ALTER TABLE tasks DROP COLUMN IF EXISTS error_code;
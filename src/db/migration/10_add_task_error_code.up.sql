@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS error_code TEXT;
+ALTER TABLE task_attempts ADD COLUMN IF NOT EXISTS error_code TEXT;
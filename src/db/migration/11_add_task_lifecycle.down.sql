@@ -0,0 +1,11 @@
+ALTER TABLE task_attempts DROP COLUMN IF EXISTS queued_at;
+
+DROP INDEX IF EXISTS idx_tasks_unfinished;
+CREATE INDEX IF NOT EXISTS idx_tasks_in_progress ON tasks(COALESCE(heartbeat_at, created_at)) WHERE status = 'in progress';
+
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_status_check;
+UPDATE tasks SET status = 'in progress'
+WHERE status IN ('queued', 'downloading', 'transcribing', 'post_processing');
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS finished_at;
+ALTER TABLE tasks DROP COLUMN IF EXISTS queued_at;
@@ -0,0 +1,19 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS queued_at TIMESTAMP;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS finished_at TIMESTAMP;
+UPDATE tasks SET queued_at = created_at WHERE queued_at IS NULL;
+ALTER TABLE tasks ALTER COLUMN queued_at SET DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE tasks SET status = CASE WHEN started_at IS NULL THEN 'queued' ELSE 'transcribing' END
+WHERE status = 'in progress';
+UPDATE tasks SET finished_at = COALESCE(heartbeat_at, created_at)
+WHERE status IN ('completed', 'failed', 'cancelled') AND finished_at IS NULL;
+
+ALTER TABLE tasks ADD CONSTRAINT tasks_status_check CHECK (
+        status IN ('queued', 'downloading', 'transcribing', 'post_processing', 'completed', 'failed', 'cancelled')
+);
+
+DROP INDEX IF EXISTS idx_tasks_in_progress;
+CREATE INDEX IF NOT EXISTS idx_tasks_unfinished ON tasks(COALESCE(heartbeat_at, queued_at))
+WHERE status IN ('queued', 'downloading', 'transcribing', 'post_processing');
+
+ALTER TABLE task_attempts ADD COLUMN IF NOT EXISTS queued_at TIMESTAMP;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_tasks_username_created;
+DROP INDEX IF EXISTS idx_tasks_tags;
+ALTER TABLE tasks DROP COLUMN IF EXISTS tags;
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS purge_log;
+
+DROP INDEX IF EXISTS idx_tasks_expires_at;
+DROP INDEX IF EXISTS idx_tasks_audio_expires_at;
+ALTER TABLE tasks DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE tasks DROP COLUMN IF EXISTS audio_purged_at;
+ALTER TABLE tasks DROP COLUMN IF EXISTS audio_expires_at;
+
+ALTER TABLE users DROP COLUMN IF EXISTS transcript_retention_days;
+ALTER TABLE users DROP COLUMN IF EXISTS audio_retention_days;
@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS audio_retention_days INT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS transcript_retention_days INT;
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS audio_expires_at TIMESTAMP;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS audio_purged_at TIMESTAMP;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_tasks_audio_expires_at ON tasks(audio_expires_at) WHERE audio_purged_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_expires_at ON tasks(expires_at);
+
+CREATE TABLE IF NOT EXISTS purge_log (
+        id BIGSERIAL PRIMARY KEY,
+        username TEXT NOT NULL,
+        task_id TEXT NOT NULL,
+        kind TEXT NOT NULL,
+        purged_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_purge_log_username ON purge_log(username, purged_at DESC);
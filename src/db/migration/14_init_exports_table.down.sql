@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS exports;
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS segments;
+ALTER TABLE tasks DROP COLUMN IF EXISTS duration;
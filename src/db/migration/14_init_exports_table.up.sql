@@ -0,0 +1,21 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS duration DOUBLE PRECISION;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS segments JSONB;
+
+CREATE TABLE IF NOT EXISTS exports (
+        id TEXT PRIMARY KEY,
+        username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+        status TEXT NOT NULL DEFAULT 'pending',
+        format TEXT NOT NULL,
+        files TEXT[] NOT NULL DEFAULT '{}',
+        filter JSONB NOT NULL,
+        task_count INTEGER NOT NULL DEFAULT 0,
+        size BIGINT NOT NULL DEFAULT 0,
+        error_message TEXT,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        started_at TIMESTAMP,
+        finished_at TIMESTAMP,
+        expires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_exports_username ON exports(username, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_exports_pending ON exports(created_at) WHERE status IN ('pending', 'running');
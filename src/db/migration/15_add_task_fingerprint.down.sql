@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_tasks_username_fingerprint;
+ALTER TABLE tasks DROP COLUMN IF EXISTS cached_from;
+ALTER TABLE tasks DROP COLUMN IF EXISTS fingerprint;
+ALTER TABLE tasks DROP COLUMN IF EXISTS no_cache;
@@ -0,0 +1,5 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS no_cache BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS fingerprint TEXT;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS cached_from TEXT;
+CREATE INDEX IF NOT EXISTS idx_tasks_username_fingerprint ON tasks(username, fingerprint, finished_at DESC)
+        WHERE status = 'completed' AND fingerprint IS NOT NULL;
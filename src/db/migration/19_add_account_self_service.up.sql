@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS session_version TEXT NOT NULL DEFAULT gen_random_uuid()::text;
+-- Usernames were case-sensitive before, so accounts differing only in case
+-- may exist. They own tasks, keys and webhooks, so stop with a list of them
+-- rather than guess which one to keep.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(names, '; ') INTO duplicates FROM (
+        SELECT string_agg(username, ', ' ORDER BY username) AS names
+        FROM users GROUP BY LOWER(username) HAVING COUNT(*) > 1
+    ) d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'rename or delete usernames that differ only in case before migrating: %', duplicates;
+    END IF;
+END $$;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower ON users(LOWER(username));
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_tasks_in_progress;
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS error_message;
+ALTER TABLE tasks DROP COLUMN IF EXISTS requeues;
+ALTER TABLE tasks DROP COLUMN IF EXISTS heartbeat_at;
+ALTER TABLE tasks DROP COLUMN IF EXISTS started_at;
@@ -0,0 +1,6 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS started_at TIMESTAMP;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS requeues INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS error_message TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_in_progress ON tasks(COALESCE(heartbeat_at, created_at)) WHERE status = 'in progress';
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_webhook_deliveries_username;
+DROP INDEX IF EXISTS idx_webhook_deliveries_pending;
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP INDEX IF EXISTS idx_webhooks_username;
+DROP TABLE IF EXISTS webhooks;
+
+ALTER TABLE users DROP COLUMN IF EXISTS webhook_secret;
+ALTER TABLE tasks DROP COLUMN IF EXISTS callback_url;
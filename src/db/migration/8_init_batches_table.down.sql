@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_tasks_batch_id;
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS language;
+ALTER TABLE tasks DROP COLUMN IF EXISTS model;
+ALTER TABLE tasks DROP COLUMN IF EXISTS batch_id;
+
+DROP INDEX IF EXISTS idx_batches_username;
+DROP TABLE IF EXISTS batches;
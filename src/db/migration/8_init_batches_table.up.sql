@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS batches (
+        id TEXT PRIMARY KEY,
+        username TEXT NOT NULL,
+        total INTEGER NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_batches_username ON batches(username);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS batch_id TEXT REFERENCES batches(id) ON DELETE SET NULL;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS model TEXT;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS language TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_batch_id ON tasks(batch_id) WHERE batch_id IS NOT NULL;
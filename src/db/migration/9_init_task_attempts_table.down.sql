@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_task_attempts_task_id;
+DROP TABLE IF EXISTS task_attempts;
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS attempt;
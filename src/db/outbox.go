@@ -0,0 +1,141 @@
+package db
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	maxOutboxBackoff = 5 * time.Minute
+	// outboxClaimTTL is how long a relay has to publish the messages it
+	// claimed before another relay may pick them up.
+	outboxClaimTTL = time.Minute
+)
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// enqueueTask writes the queue message for a task to the outbox.
+func enqueueTask(e execer, message types.AudioMessage, queueName string) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = e.Exec(
+		"INSERT INTO outbox (task_id, queue, payload) VALUES ($1, $2, $3)",
+		message.TaskID, queueName, payload,
+	)
+	return err
+}
+
+// outboxBackoff returns the delay before the next publish attempt:
+// 1s, 2s, 4s, ... capped at maxOutboxBackoff.
+func outboxBackoff(attempts int) time.Duration {
+	if attempts > 16 {
+		return maxOutboxBackoff
+	}
+	d := time.Second << attempts
+	if d > maxOutboxBackoff {
+		return maxOutboxBackoff
+	}
+	return d
+}
+
+// ProcessOutbox claims up to limit due messages, hands each to publish and
+// marks it sent. Claiming pushes next_attempt_at outboxClaimTTL ahead, so
+// the rows are neither locked nor picked up by other relays while publish
+// runs outside any transaction; if the relay dies, they become due again
+// once the claim runs out. On the first publish error the message is
+// rescheduled with backoff, the rest of the batch is released and
+// processing stops, since the broker is most likely unavailable.
+func (s *Store) ProcessOutbox(limit int, publish func(types.OutboxMessage) error) (int, error) {
+	messages, err := s.claimOutbox(limit)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for i, msg := range messages {
+		if publishErr := publish(msg); publishErr != nil {
+			if _, err := s.db.Exec(
+				`UPDATE outbox SET attempts = attempts + 1, last_error = $2,
+					next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond'
+				WHERE id = $1`,
+				msg.ID, publishErr.Error(), outboxBackoff(msg.Attempts).Milliseconds(),
+			); err != nil {
+				return sent, err
+			}
+			return sent, s.releaseOutbox(messages[i+1:])
+		}
+		if _, err := s.db.Exec(
+			"UPDATE outbox SET attempts = attempts + 1, last_error = NULL, sent_at = NOW() WHERE id = $1",
+			msg.ID,
+		); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// claimOutbox reserves up to limit due messages for this relay, in the
+// order they were written. Rows are picked with SKIP LOCKED so several
+// relays can claim concurrently.
+func (s *Store) claimOutbox(limit int) ([]types.OutboxMessage, error) {
+	rows, err := s.db.Query(`
+		UPDATE outbox SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE sent_at IS NULL AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, task_id, queue, payload, attempts`,
+		limit, outboxClaimTTL.Milliseconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []types.OutboxMessage
+	for rows.Next() {
+		var msg types.OutboxMessage
+		if err := rows.Scan(&msg.ID, &msg.TaskID, &msg.Queue, &msg.Payload, &msg.Attempts); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	slices.SortFunc(messages, func(a, b types.OutboxMessage) int { return cmp.Compare(a.ID, b.ID) })
+	return messages, rows.Err()
+}
+
+// releaseOutbox makes claimed messages that were not published due again.
+func (s *Store) releaseOutbox(messages []types.OutboxMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make(pq.Int64Array, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	_, err := s.db.Exec("UPDATE outbox SET next_attempt_at = NOW() WHERE id = ANY($1) AND sent_at IS NULL", ids)
+	return err
+}
+
+// PurgeSentOutbox removes messages that were published more than olderThan ago.
+func (s *Store) PurgeSentOutbox(olderThan time.Duration) error {
+	_, err := s.db.Exec(
+		"DELETE FROM outbox WHERE sent_at IS NOT NULL AND sent_at < NOW() - $1 * INTERVAL '1 millisecond'",
+		olderThan.Milliseconds(),
+	)
+	return err
+}
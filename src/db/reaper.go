@@ -0,0 +1,123 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"speechToText/src/types"
+	"time"
+)
+
+// reaperLockKey identifies the advisory lock held while reaping, so only one
+// instance scans for stuck tasks at a time.
+const reaperLockKey = 7_251_001
+
+type stuckTask struct {
+	message  types.AudioMessage
+	requeues int
+}
+
+// ReapStuckTasks handles tasks nobody is working on. Active tasks with no
+// heartbeat for longer than timeout are taken from their worker: those
+// requeued fewer than maxRequeues times go back to queued with a fresh
+// outbox message, the rest fail with worker_timeout. Queued tasks are only
+// waiting for a worker, however long the backlog; if their last message was
+// published more than republishAfter ago, or is gone, the message is
+// published again, which costs nothing if the original is still queued as
+// the worker drops duplicates. If another instance holds the reaper lock,
+// nothing is done.
+func (s *Store) ReapStuckTasks(timeout time.Duration, maxRequeues int, republishAfter time.Duration, queueName string) (types.ReapResult, error) {
+	var result types.ReapResult
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow("SELECT pg_try_advisory_xact_lock($1)", reaperLockKey).Scan(&locked); err != nil {
+		return result, err
+	}
+	if !locked {
+		return result, nil
+	}
+
+	stuck, err := selectStuckTasks(tx, `
+		SELECT task_id, audio, COALESCE(model, ''), COALESCE(language, ''), no_cache, requeues
+		FROM tasks
+		WHERE status = ANY($2)
+		  AND COALESCE(heartbeat_at, started_at, queued_at) < NOW() - $1 * INTERVAL '1 millisecond'
+		FOR UPDATE SKIP LOCKED`,
+		timeout.Milliseconds(), activeStatuses(),
+	)
+	if err != nil {
+		return result, err
+	}
+	for _, task := range stuck {
+		taskID := task.message.TaskID
+		if task.requeues < maxRequeues {
+			requeued, err := requeueActiveTask(tx, task.message, queueName)
+			if err != nil {
+				return result, err
+			}
+			if requeued {
+				result.Requeued = append(result.Requeued, taskID)
+			}
+			continue
+		}
+		failed, err := transitionTaskIn(tx, taskID, types.StatusFailed,
+			`UPDATE tasks SET status = $2, error_code = $4, error_message = $5, finished_at = NOW()
+			WHERE task_id = $1 AND status = ANY($3)`,
+			types.ErrorWorkerTimeout, fmt.Sprintf("no progress for %s after %d requeues", timeout, task.requeues),
+		)
+		if err != nil {
+			return result, err
+		}
+		if failed {
+			result.Failed = append(result.Failed, taskID)
+		}
+	}
+
+	lost, err := selectStuckTasks(tx, `
+		SELECT t.task_id, t.audio, COALESCE(t.model, ''), COALESCE(t.language, ''), t.no_cache, t.requeues
+		FROM tasks t
+		WHERE t.status = $2
+		  AND t.queued_at < NOW() - $1 * INTERVAL '1 millisecond'
+		  AND NOT EXISTS (
+			SELECT 1 FROM outbox o
+			WHERE o.task_id = t.task_id
+			  AND (o.sent_at IS NULL OR o.sent_at >= NOW() - $1 * INTERVAL '1 millisecond')
+		  )
+		FOR UPDATE OF t SKIP LOCKED`,
+		republishAfter.Milliseconds(), types.StatusQueued,
+	)
+	if err != nil {
+		return result, err
+	}
+	for _, task := range lost {
+		if err := enqueueTask(tx, task.message, queueName); err != nil {
+			return result, err
+		}
+		result.Republished = append(result.Republished, task.message.TaskID)
+	}
+	return result, tx.Commit()
+}
+
+func selectStuckTasks(tx *sql.Tx, query string, args ...any) ([]stuckTask, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stuck []stuckTask
+	for rows.Next() {
+		var task stuckTask
+		if err := rows.Scan(
+			&task.message.TaskID, &task.message.Audio, &task.message.Model, &task.message.Language, &task.message.NoCache, &task.requeues,
+		); err != nil {
+			return nil, err
+		}
+		stuck = append(stuck, task)
+	}
+	return stuck, rows.Err()
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"speechToText/src/config"
+	"speechToText/src/types"
+	"time"
+)
+
+// expiryAfter renders the expiry of data created at base under the user's
+// retention column, falling back to the default days in placeholder. Zero
+// days means no expiry.
+func expiryAfter(base string, column string, placeholder string) string {
+	return fmt.Sprintf("%s + NULLIF(COALESCE(%s, %s), 0) * INTERVAL '1 day'", base, column, placeholder)
+}
+
+func (s *Store) GetRetention(username string) (types.RetentionSettings, error) {
+	var settings types.RetentionSettings
+	var audioDays, transcriptDays sql.NullInt64
+	err := s.db.QueryRow(
+		"SELECT audio_retention_days, transcript_retention_days FROM users WHERE username = $1",
+		username,
+	).Scan(&audioDays, &transcriptDays)
+	if audioDays.Valid {
+		days := int(audioDays.Int64)
+		settings.AudioDays = &days
+	}
+	if transcriptDays.Valid {
+		days := int(transcriptDays.Int64)
+		settings.TranscriptDays = &days
+	}
+	return settings, err
+}
+
+// SetRetention stores the user's retention settings and recomputes the
+// expiry of their existing tasks.
+func (s *Store) SetRetention(username string, settings types.RetentionSettings) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE users SET audio_retention_days = $2, transcript_retention_days = $3 WHERE username = $1",
+		username, settings.AudioDays, settings.TranscriptDays,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE tasks t
+		SET audio_expires_at = CASE WHEN t.audio_purged_at IS NULL THEN `+expiryAfter("t.created_at", "u.audio_retention_days", "$2")+` END,
+			expires_at = `+expiryAfter("t.created_at", "u.transcript_retention_days", "$3")+`
+		FROM users u
+		WHERE u.username = t.username AND t.username = $1`,
+		username, config.CurrentConfig.Retention.AudioDays, config.CurrentConfig.Retention.TranscriptDays,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeExpiredAudio erases the source URL of up to limit finished tasks whose
+// audio retention has passed, records them in the purge log and returns how
+// many were purged.
+func (s *Store) PurgeExpiredAudio(limit int) (int64, error) {
+	result, err := s.db.Exec(`
+		WITH expired AS (
+			SELECT task_id FROM tasks
+			WHERE audio_expires_at <= NOW() AND audio_purged_at IS NULL AND status = ANY($2)
+			ORDER BY audio_expires_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		), purged AS (
+			UPDATE tasks t SET audio = '', audio_purged_at = NOW()
+			FROM expired e
+			WHERE t.task_id = e.task_id
+			RETURNING t.username, t.task_id
+		)
+		INSERT INTO purge_log (username, task_id, kind)
+		SELECT username, task_id, $3 FROM purged`,
+		limit, finalStatuses(), types.PurgeAudio,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PurgeExpiredTasks deletes up to limit finished tasks whose transcript
+// retention has passed, along with their attempts, webhook deliveries and
+// outbox messages, records them in the purge log and returns how many were
+// purged.
+func (s *Store) PurgeExpiredTasks(limit int) (int64, error) {
+	result, err := s.db.Exec(`
+		WITH expired AS (
+			SELECT task_id FROM tasks
+			WHERE expires_at <= NOW() AND status = ANY($2)
+			ORDER BY expires_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		), purged AS (
+			DELETE FROM tasks t
+			USING expired e
+			WHERE t.task_id = e.task_id
+			RETURNING t.username, t.task_id
+		), deliveries AS (
+			DELETE FROM webhook_deliveries d USING purged p WHERE d.task_id = p.task_id
+		), messages AS (
+			DELETE FROM outbox o USING purged p WHERE o.task_id = p.task_id
+		)
+		INSERT INTO purge_log (username, task_id, kind)
+		SELECT username, task_id, $3 FROM purged`,
+		limit, finalStatuses(), types.PurgeTask,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListPurges returns the user's most recent purge log entries.
+func (s *Store) ListPurges(username string, limit int) ([]types.PurgeRecord, error) {
+	rows, err := s.db.Query(`
+		SELECT task_id, kind, purged_at
+		FROM purge_log
+		WHERE username = $1
+		ORDER BY purged_at DESC, id DESC
+		LIMIT $2`,
+		username, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purges := []types.PurgeRecord{}
+	for rows.Next() {
+		var record types.PurgeRecord
+		var purgedAt time.Time
+		if err := rows.Scan(&record.TaskID, &record.Kind, &purgedAt); err != nil {
+			return nil, err
+		}
+		record.PurgedAt = purgedAt.Format(time.RFC3339)
+		purges = append(purges, record)
+	}
+	return purges, rows.Err()
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// retryTask archives the current attempt of a failed task, moves it back to
+// queued with the given option overrides and queues it again. It returns
+// false if the task is not a failed task of the user.
+func retryTask(tx *sql.Tx, taskID string, username string, overrides types.TranscriptionOptions, queueName string) (bool, error) {
+	message := types.AudioMessage{TaskID: taskID}
+	var attempt int
+	err := tx.QueryRow(`
+		SELECT audio, COALESCE(model, ''), COALESCE(language, ''), no_cache, attempt
+		FROM tasks
+		WHERE task_id = $1 AND username = $2 AND status = $3 AND audio_purged_at IS NULL
+		FOR UPDATE`,
+		taskID, username, types.StatusFailed,
+	).Scan(&message.Audio, &message.Model, &message.Language, &message.NoCache, &attempt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO task_attempts (task_id, attempt, status, error_code, error_message, model, language, queued_at, started_at, finished_at)
+		SELECT task_id, attempt, status, error_code, error_message, model, language, queued_at, started_at, COALESCE(finished_at, NOW())
+		FROM tasks WHERE task_id = $1`,
+		taskID,
+	); err != nil {
+		return false, err
+	}
+
+	if overrides.Model != "" {
+		message.Model = overrides.Model
+	}
+	if overrides.Language != "" {
+		message.Language = overrides.Language
+	}
+	moved, err := transitionTaskIn(tx, taskID, types.StatusQueued, `
+		UPDATE tasks
+		SET status = $2, result = NULL, error_code = NULL, error_message = NULL, fingerprint = NULL,
+			queued_at = NOW(), started_at = NULL, finished_at = NULL, heartbeat_at = NULL,
+			requeues = 0, attempt = attempt + 1,
+			model = NULLIF($4, ''), language = NULLIF($5, '')
+		WHERE task_id = $1 AND status = ANY($3)`,
+		message.Model, message.Language,
+	)
+	if err != nil || !moved {
+		return false, err
+	}
+	return true, enqueueTask(tx, message, queueName)
+}
+
+func (s *Store) RetryTask(taskID string, username string, overrides types.TranscriptionOptions, queueName string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	retried, err := retryTask(tx, taskID, username, overrides, queueName)
+	if err != nil || !retried {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RetryTasks retries every failed task of the user matching the filter, up to
+// filter.Limit tasks, and returns their IDs.
+func (s *Store) RetryTasks(username string, filter types.TaskFilter, overrides types.TranscriptionOptions, queueName string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT task_id FROM tasks
+		WHERE username = $1 AND status = $7 AND audio_purged_at IS NULL
+		  AND (cardinality($2::text[]) = 0 OR task_id = ANY($2))
+		  AND ($3 = '' OR batch_id = $3)
+		  AND ($4::timestamp IS NULL OR created_at >= $4)
+		  AND ($5::timestamp IS NULL OR created_at < $5)
+		ORDER BY created_at
+		LIMIT $6`,
+		username, pq.Array(filter.TaskIDs), filter.BatchID,
+		nullTime(filter.CreatedAfter), nullTime(filter.CreatedBefore), filter.Limit, types.StatusFailed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var taskIDs []string
+	for rows.Next() {
+		var taskID string
+		if err := rows.Scan(&taskID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		taskIDs = append(taskIDs, taskID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	retried := []string{}
+	for _, taskID := range taskIDs {
+		ok, err := retryTask(tx, taskID, username, overrides, queueName)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			retried = append(retried, taskID)
+		}
+	}
+	return retried, tx.Commit()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// formatTime renders a nullable timestamp as RFC 3339, or "" if it is NULL.
+func formatTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.RFC3339)
+}
+
+// GetTaskAttempts returns the current attempt number and the archived
+// previous attempts of a task, oldest first.
+func (s *Store) GetTaskAttempts(taskID string) (int, []types.TaskAttempt, error) {
+	var current int
+	if err := s.db.QueryRow("SELECT attempt FROM tasks WHERE task_id = $1", taskID).Scan(&current); err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT attempt, status, error_code, error_message, model, language, queued_at, started_at, finished_at
+		FROM task_attempts
+		WHERE task_id = $1
+		ORDER BY attempt`,
+		taskID,
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	attempts := []types.TaskAttempt{}
+	for rows.Next() {
+		var attempt types.TaskAttempt
+		var errorCode, errorMessage, model, language sql.NullString
+		var queuedAt, startedAt, finishedAt sql.NullTime
+		if err := rows.Scan(
+			&attempt.Attempt, &attempt.Status, &errorCode, &errorMessage, &model, &language, &queuedAt, &startedAt, &finishedAt,
+		); err != nil {
+			return 0, nil, err
+		}
+		attempt.ErrorCode = errorCode.String
+		attempt.Error = errorMessage.String
+		attempt.Model = model.String
+		attempt.Language = language.String
+		attempt.QueuedAt = formatTime(queuedAt)
+		attempt.StartedAt = formatTime(startedAt)
+		attempt.FinishedAt = formatTime(finishedAt)
+		attempts = append(attempts, attempt)
+	}
+	return current, attempts, rows.Err()
+}
@@ -1,13 +1,18 @@
 package db
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"speechToText/src/config"
 	"speechToText/src/service"
 	"speechToText/src/types"
+	"sync"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,8 +24,17 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
+// AddAuthData creates the user together with the secret that signs their
+// callback_url deliveries.
 func (s *Store) AddAuthData(username string, password string) error {
-	_, err := s.db.Exec("INSERT INTO users (username, password) VALUES ($1, $2)", username, password)
+	secret, err := newSecret()
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO users (username, password, webhook_secret) VALUES ($1, $2, $3)",
+		username, password, secret,
+	)
 	return err
 }
 
@@ -29,59 +43,293 @@ func HashPassword(password string) (string, error) {
 	return string(bytes), err
 }
 
-func (s *Store) CheckAuthData(username string, password string) (bool, error) {
-	var hashedPassword string
-	err := s.db.QueryRow("SELECT password FROM users WHERE username = $1", username).Scan(&hashedPassword)
+// unknownUserHash is a hash of a random password at the cost of real ones,
+// checked against when the user does not exist.
+var unknownUserHash = sync.OnceValue(func() []byte {
+	password := make([]byte, 32)
+	_, _ = rand.Read(password)
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// CheckAuthData verifies the password of the user, whose name is matched
+// case-insensitively, and returns the name as stored.
+func (s *Store) CheckAuthData(username string, password string) (string, bool, error) {
+	var stored, hashedPassword string
+	err := s.db.QueryRow(
+		"SELECT username, password FROM users WHERE LOWER(username) = LOWER($1)",
+		username,
+	).Scan(&stored, &hashedPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return false, nil
+			// Spend as long as for a wrong password, so response times do
+			// not tell which usernames exist.
+			bcrypt.CompareHashAndPassword(unknownUserHash(), []byte(password))
+			return "", false, nil
 		}
-		return false, err
+		return "", false, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+		return "", false, nil
+	}
+	return stored, true, nil
+}
+
+// AddAudioTask inserts the task together with its queue message in one
+// transaction, so a task is never stored without a pending publish.
+func (s *Store) AddAudioTask(taskID string, username string, request types.AudioRequest, queueName string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertTask(tx, taskID, username, "", request, queueName); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertTask(e queryer, taskID string, username string, batchID string, request types.AudioRequest, queueName string) error {
+	if _, err := e.Exec(`
+		INSERT INTO tasks (
+			username, task_id, audio, status, callback_url, batch_id, model, language, tags,
+			audio_expires_at, expires_at, no_cache
+		)
+		SELECT $1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), COALESCE($9::text[], '{}'),
+			`+expiryAfter("NOW()", "u.audio_retention_days", "$10")+`,
+			`+expiryAfter("NOW()", "u.transcript_retention_days", "$11")+`,
+			$12
+		FROM (SELECT 1) AS one
+		LEFT JOIN users u ON u.username = $1`,
+		username, taskID, request.Audio, types.StatusQueued, request.CallbackURL, batchID, request.Model, request.Language,
+		pq.Array(request.Tags), config.CurrentConfig.Retention.AudioDays, config.CurrentConfig.Retention.TranscriptDays,
+		request.NoCache,
+	); err != nil {
+		return err
 	}
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil, nil
+	if request.CallbackURL != "" {
+		// Users created before secrets were generated at registration get
+		// theirs with the first task that needs it.
+		if _, err := callbackSecret(e, username); err != nil {
+			return err
+		}
+	}
+	return enqueueTask(e, types.AudioMessage{
+		TaskID:               taskID,
+		Audio:                request.Audio,
+		NoCache:              request.NoCache,
+		TranscriptionOptions: request.TranscriptionOptions,
+	}, queueName)
+}
+
+func (s *Store) GetStatusTask(taskID string) (types.GetStatusResponse, error) {
+	var status types.GetStatusResponse
+	var errorCode, errorMessage sql.NullString
+	var queuedAt, startedAt, finishedAt, audioExpiresAt, expiresAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT status, error_code, error_message, queued_at, started_at, finished_at, audio_expires_at, expires_at,
+			COALESCE(cached_from, '')
+		FROM tasks WHERE task_id = $1`,
+		taskID,
+	).Scan(
+		&status.Status, &errorCode, &errorMessage, &queuedAt, &startedAt, &finishedAt, &audioExpiresAt, &expiresAt,
+		&status.CachedFrom,
+	)
+	status.ErrorCode = errorCode.String
+	status.Error = errorMessage.String
+	status.QueuedAt = formatTime(queuedAt)
+	status.StartedAt = formatTime(startedAt)
+	status.FinishedAt = formatTime(finishedAt)
+	status.AudioExpiresAt = formatTime(audioExpiresAt)
+	status.ExpiresAt = formatTime(expiresAt)
+	return status, err
+}
+
+// GetTaskEvent returns the task's current status as an event for subscribers.
+func (s *Store) GetTaskEvent(taskID string) (types.TaskEvent, error) {
+	event := types.TaskEvent{TaskID: taskID, Time: time.Now().UTC().Format(time.RFC3339)}
+	var errorCode, errorMessage sql.NullString
+	err := s.db.QueryRow(
+		"SELECT username, status, error_code, error_message FROM tasks WHERE task_id = $1",
+		taskID,
+	).Scan(&event.Username, &event.Status, &errorCode, &errorMessage)
+	event.ErrorCode = errorCode.String
+	event.Error = errorMessage.String
+	return event, err
+}
+
+func (s *Store) GetResultTask(taskID string) (types.GetResultResponse, error) {
+	var response types.GetResultResponse
+	var result, errorCode, errorMessage sql.NullString
+	err := s.db.QueryRow(
+		"SELECT result, error_code, error_message FROM tasks WHERE task_id = $1",
+		taskID,
+	).Scan(&result, &errorCode, &errorMessage)
+	if err != nil {
+		return response, err
+	}
+	response.ErrorCode = errorCode.String
+	response.Error = errorMessage.String
+	if result.Valid {
+		response.Result = result.String
+	} else {
+		response.Result = "in progress"
+	}
+	return response, nil
+}
+
+// StartTask moves a queued task to downloading when a worker picks it up. It
+// returns false when the task is not queued (already running, finished or
+// deleted), in which case the message is a duplicate and should be dropped.
+func (s *Store) StartTask(taskID string) (bool, error) {
+	return s.transitionTask(taskID, types.StatusDownloading,
+		"UPDATE tasks SET status = $2, started_at = NOW(), heartbeat_at = NOW() WHERE task_id = $1 AND status = ANY($3)",
+	)
+}
+
+// AdvanceTask moves a running task to the next processing stage. It returns
+// false if the transition is not allowed, e.g. because the task was cancelled.
+func (s *Store) AdvanceTask(taskID string, status types.TaskStatus) (bool, error) {
+	return s.transitionTask(taskID, status,
+		"UPDATE tasks SET status = $2, heartbeat_at = NOW() WHERE task_id = $1 AND status = ANY($3)",
+	)
+}
+
+// ReleaseTask puts a task a worker gave up on while shutting down back to
+// queued, so whichever worker receives the redelivered message can start it.
+func (s *Store) ReleaseTask(taskID string) (bool, error) {
+	return s.transitionTask(taskID, types.StatusQueued,
+		`UPDATE tasks SET status = $2, started_at = NULL, heartbeat_at = NOW()
+		WHERE task_id = $1 AND status = ANY($3) AND status = ANY($4)`,
+		activeStatuses(),
+	)
 }
 
-func (s *Store) AddAudioTask(taskID string, username string, audio string) error {
+func (s *Store) HeartbeatTask(taskID string) error {
 	_, err := s.db.Exec(
-		"INSERT INTO tasks (username, task_id, audio, status) VALUES ($1, $2, $3, $4)",
-		username, taskID, audio, "in progress",
+		"UPDATE tasks SET heartbeat_at = NOW() WHERE task_id = $1 AND status = ANY($2)",
+		taskID, statusArray(types.UnfinishedStatuses()),
 	)
 	return err
 }
 
-func (s *Store) GetStatusTask(taskID string) (string, error) {
-	var status string
-	err := s.db.QueryRow("SELECT status FROM tasks WHERE task_id = $1", taskID).Scan(&status)
-	return status, err
+var statusEvents = map[types.TaskStatus]string{
+	types.StatusCompleted: types.EventTaskCompleted,
+	types.StatusFailed:    types.EventTaskFailed,
+	types.StatusCancelled: types.EventTaskCancelled,
+}
+
+func finalStatuses() pq.StringArray {
+	var statuses []types.TaskStatus
+	for _, status := range types.TaskStatuses {
+		if status.IsFinal() {
+			statuses = append(statuses, status)
+		}
+	}
+	return statusArray(statuses)
+}
+
+func activeStatuses() pq.StringArray {
+	var statuses []types.TaskStatus
+	for _, status := range types.TaskStatuses {
+		if status.IsActive() {
+			statuses = append(statuses, status)
+		}
+	}
+	return statusArray(statuses)
 }
 
-func (s *Store) GetResultTask(taskID string) (string, error) {
-	var result sql.NullString
-	err := s.db.QueryRow("SELECT result FROM tasks WHERE task_id = $1", taskID).Scan(&result)
+func statusArray(statuses []types.TaskStatus) pq.StringArray {
+	array := make(pq.StringArray, len(statuses))
+	for i, status := range statuses {
+		array[i] = string(status)
+	}
+	return array
+}
+
+// transitionTask runs a status-changing UPDATE guarded by the lifecycle. The
+// query receives the task ID as $1, the new status as $2 and the statuses it
+// may be reached from as $3; args start at $4. If the task moved, the webhook
+// event for the new status is queued in the same transaction.
+func (s *Store) transitionTask(taskID string, to types.TaskStatus, query string, args ...any) (bool, error) {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return "", err
+		return false, err
 	}
-	if result.Valid {
-		return result.String, nil
+	defer tx.Rollback()
+
+	moved, err := transitionTaskIn(tx, taskID, to, query, args...)
+	if err != nil || !moved {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// transitionTaskIn is transitionTask within the caller's transaction.
+func transitionTaskIn(q queryer, taskID string, to types.TaskStatus, query string, args ...any) (bool, error) {
+	params := append([]any{taskID, to, statusArray(types.TransitionsInto(to))}, args...)
+	result, err := q.Exec(query, params...)
+	if err != nil {
+		return false, err
 	}
-	return "in progress", nil
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		return false, err
+	}
+	if event, ok := statusEvents[to]; ok {
+		if err := enqueueWebhookEvent(q, taskID, event); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// requeueActiveTask takes a running task away from its worker and queues a
+// new message for it, counting the requeue. It returns false if the task is
+// not active.
+func requeueActiveTask(q queryer, message types.AudioMessage, queueName string) (bool, error) {
+	moved, err := transitionTaskIn(q, message.TaskID, types.StatusQueued,
+		`UPDATE tasks SET status = $2, requeues = requeues + 1, started_at = NULL, heartbeat_at = NOW()
+		WHERE task_id = $1 AND status = ANY($3) AND status = ANY($4)`,
+		activeStatuses(),
+	)
+	if err != nil || !moved {
+		return false, err
+	}
+	return true, enqueueTask(q, message, queueName)
 }
 
-func (s *Store) AddResultTask(taskID string, text string) error {
+func (s *Store) AddResultTask(taskID string, transcript types.Transcript) error {
 	service.LogDebug("ADD RESULT TASK IS WORKING!")
-	service.LogDebug("TEXT: %s", text)
-	_, err := s.db.Exec("UPDATE tasks SET result = $2, status = 'completed' WHERE task_id = $1", taskID, text)
+	service.LogDebug("TEXT: %s", transcript.Text)
+	segments, err := json.Marshal(transcript.Segments)
+	if err != nil {
+		return err
+	}
+	_, err = s.transitionTask(taskID, types.StatusCompleted,
+		`UPDATE tasks SET status = $2, result = $4, duration = $5, segments = $6, finished_at = NOW()
+		WHERE task_id = $1 AND status = ANY($3)`,
+		transcript.Text, transcript.Duration, segments,
+	)
 	return err
 }
 
-func (s *Store) UpdateTaskFailed(taskID string) error {
-	_, err := s.db.Exec("UPDATE tasks SET status = 'failed' WHERE task_id = $1", taskID)
+func (s *Store) UpdateTaskFailed(taskID string, code string, message string) error {
+	_, err := s.transitionTask(taskID, types.StatusFailed,
+		`UPDATE tasks SET status = $2, error_code = $4, error_message = $5, finished_at = NOW()
+		WHERE task_id = $1 AND status = ANY($3)`,
+		code, message,
+	)
 	return err
 }
 
 func (s *Store) ExistUsername(username string) (bool, error) {
 	var exists bool
-	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists)
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))", username).Scan(&exists)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false, nil
@@ -115,36 +363,11 @@ func (s *Store) DeleteTask(taskID string, username string) error {
 	return nil
 }
 
-func (s *Store) GetTasksWithPagination(username string, page, pageSize int) ([]types.TaskInfo, int64, error) {
-	offset := (page - 1) * pageSize
-
-	var total int64
-	if err := s.db.QueryRow("SELECT COUNT(*) FROM tasks WHERE username = $1", username).Scan(&total); err != nil {
-		return nil, 0, err
-	}
-
-	rows, err := s.db.Query(`
-		SELECT task_id, username, status, created_at
-		FROM tasks
-		WHERE username = $1
-		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3`,
-		username, pageSize, offset,
+// CancelTask moves a queued or running task to cancelled. It returns false if
+// the task has already finished.
+func (s *Store) CancelTask(taskID string, username string) (bool, error) {
+	return s.transitionTask(taskID, types.StatusCancelled,
+		"UPDATE tasks SET status = $2, finished_at = NOW() WHERE task_id = $1 AND status = ANY($3) AND username = $4",
+		username,
 	)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer rows.Close()
-
-	var tasks []types.TaskInfo
-	for rows.Next() {
-		var task types.TaskInfo
-		var createdAt time.Time
-		if err := rows.Scan(&task.TaskID, &task.Username, &task.Status, &createdAt); err != nil {
-			return nil, 0, err
-		}
-		task.Created = createdAt.Format(time.RFC3339)
-		tasks = append(tasks, task)
-	}
-	return tasks, total, rows.Err()
 }
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"speechToText/src/types"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// taskSortKeys maps sort fields to the expression they order by, in addition
+// to created_at and task_id.
+var taskSortKeys = map[string]string{
+	types.SortCreatedAt: "",
+	types.SortStatus:    "status",
+	types.SortLanguage:  "COALESCE(language, '')",
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// taskConditions renders the user and filter as SQL conditions over tasks,
+// appending their arguments to args.
+func taskConditions(username string, filter types.TaskFilter, args []any) ([]string, []any) {
+	var conditions []string
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	add("username = $%d", username)
+	if len(filter.TaskIDs) > 0 {
+		add("task_id = ANY($%d)", pq.Array(filter.TaskIDs))
+	}
+	if filter.BatchID != "" {
+		add("batch_id = $%d", filter.BatchID)
+	}
+	if len(filter.Statuses) > 0 {
+		add("status = ANY($%d)", statusArray(filter.Statuses))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		add("created_at >= $%d", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		add("created_at < $%d", filter.CreatedBefore)
+	}
+	if filter.Language != "" {
+		add("language = $%d", filter.Language)
+	}
+	if filter.Tag != "" {
+		add("tags @> ARRAY[$%d::text]", filter.Tag)
+	}
+	if filter.TextPrefix != "" {
+		add(`result ILIKE $%d ESCAPE '\'`, likeEscaper.Replace(filter.TextPrefix)+"%")
+	}
+	return conditions, args
+}
+
+// ListTasks returns up to query.Filter.Limit of the user's tasks matching the
+// filter in the requested order, and the cursor of the last one if more
+// tasks follow. The cursor's Sort is left for the caller to set.
+func (s *Store) ListTasks(username string, query types.TaskQuery) ([]types.TaskInfo, *types.TaskCursor, error) {
+	sortKey, ok := taskSortKeys[query.Sort]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sort field %q", query.Sort)
+	}
+	keys := []string{"created_at", "task_id"}
+	if sortKey != "" {
+		keys = append([]string{sortKey}, keys...)
+	} else {
+		sortKey = "''"
+	}
+	direction, compare := "ASC", ">"
+	if query.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	conditions, args := taskConditions(username, query.Filter, nil)
+	if after := query.After; after != nil {
+		values := []any{after.Created, after.TaskID}
+		if len(keys) == 3 {
+			values = append([]any{after.Key}, values...)
+		}
+		placeholders := make([]string, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(
+			"(%s) %s (%s)", strings.Join(keys, ", "), compare, strings.Join(placeholders, ", "),
+		))
+	}
+	order := make([]string, len(keys))
+	for i, key := range keys {
+		order[i] = key + " " + direction
+	}
+	args = append(args, query.Filter.Limit+1, query.Offset)
+
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT task_id, username, status, language, tags, error_code, error_message,
+			created_at, queued_at, started_at, finished_at, audio_expires_at, expires_at,
+			COALESCE(cached_from, ''), %s
+		FROM tasks
+		WHERE %s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d`,
+		sortKey, strings.Join(conditions, " AND "), strings.Join(order, ", "), len(args)-1, len(args),
+	), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	tasks := []types.TaskInfo{}
+	var last types.TaskCursor
+	for rows.Next() {
+		var task types.TaskInfo
+		var language, errorCode, errorMessage sql.NullString
+		var tags pq.StringArray
+		var createdAt time.Time
+		var queuedAt, startedAt, finishedAt, audioExpiresAt, expiresAt sql.NullTime
+		var key string
+		if err := rows.Scan(
+			&task.TaskID, &task.Username, &task.Status, &language, &tags, &errorCode, &errorMessage,
+			&createdAt, &queuedAt, &startedAt, &finishedAt, &audioExpiresAt, &expiresAt,
+			&task.CachedFrom, &key,
+		); err != nil {
+			return nil, nil, err
+		}
+		if len(tasks) == query.Filter.Limit {
+			return tasks, &last, rows.Err()
+		}
+		task.Language = language.String
+		task.Tags = append([]string{}, tags...)
+		task.ErrorCode = errorCode.String
+		task.Error = errorMessage.String
+		task.Created = createdAt.Format(time.RFC3339)
+		task.QueuedAt = formatTime(queuedAt)
+		task.StartedAt = formatTime(startedAt)
+		task.FinishedAt = formatTime(finishedAt)
+		task.AudioExpiresAt = formatTime(audioExpiresAt)
+		task.ExpiresAt = formatTime(expiresAt)
+		tasks = append(tasks, task)
+		last = types.TaskCursor{Key: key, Created: createdAt, TaskID: task.TaskID}
+	}
+	return tasks, nil, rows.Err()
+}
+
+// CountTasks returns how many of the user's tasks match the filter.
+func (s *Store) CountTasks(username string, filter types.TaskFilter) (int64, error) {
+	conditions, args := taskConditions(username, filter, nil)
+	var total int64
+	err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM tasks WHERE "+strings.Join(conditions, " AND "),
+		args...,
+	).Scan(&total)
+	return total, err
+}
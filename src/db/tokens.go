@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrTokenReused is returned when a refresh token that was already rotated
+// is presented again. The whole family is revoked, since either the client
+// or an attacker holds a stolen copy.
+var ErrTokenReused = errors.New("refresh token reused")
+
+const insertRefreshToken = `
+	INSERT INTO refresh_tokens (id, family_id, username, token_hash, expires_at)
+	VALUES ($1, $2, $3, $4, NOW() + $5 * INTERVAL '1 millisecond')`
+
+// CreateRefreshToken stores a refresh token starting a new family and
+// returns the family ID.
+func (s *Store) CreateRefreshToken(username string, tokenHash string, ttl time.Duration) (string, error) {
+	familyID := uuid.New().String()
+	_, err := s.db.Exec(insertRefreshToken, uuid.New().String(), familyID, username, tokenHash, ttl.Milliseconds())
+	return familyID, err
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family and returns the user, their role and the family. Unknown, revoked
+// and expired tokens and those of disabled users return sql.ErrNoRows;
+// reused ones revoke the family and return ErrTokenReused.
+func (s *Store) RotateRefreshToken(tokenHash string, newHash string, ttl time.Duration) (username string, role string, familyID string, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", "", "", err
+	}
+	defer tx.Rollback()
+
+	var id string
+	var live, used, revoked, disabled bool
+	err = tx.QueryRow(`
+		SELECT t.id, t.family_id, t.username, u.role, t.expires_at > NOW(), t.used_at IS NOT NULL,
+			t.revoked_at IS NOT NULL, u.disabled_at IS NOT NULL
+		FROM refresh_tokens t JOIN users u ON u.username = t.username
+		WHERE t.token_hash = $1
+		FOR UPDATE OF t`,
+		tokenHash,
+	).Scan(&id, &familyID, &username, &role, &live, &used, &revoked, &disabled)
+	if err != nil {
+		return "", "", "", err
+	}
+	if revoked || disabled || !live {
+		return "", "", "", sql.ErrNoRows
+	}
+	if used {
+		if _, err := tx.Exec(
+			"UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL",
+			familyID,
+		); err != nil {
+			return "", "", "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", "", "", err
+		}
+		return "", "", "", ErrTokenReused
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1", id); err != nil {
+		return "", "", "", err
+	}
+	if _, err := tx.Exec(insertRefreshToken, uuid.New().String(), familyID, username, newHash, ttl.Milliseconds()); err != nil {
+		return "", "", "", err
+	}
+	return username, role, familyID, tx.Commit()
+}
+
+// RevokeRefreshFamily revokes every refresh token of the family.
+func (s *Store) RevokeRefreshFamily(familyID string) error {
+	_, err := s.db.Exec(
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL",
+		familyID,
+	)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of the user.
+func (s *Store) RevokeUserRefreshTokens(username string) error {
+	_, err := s.db.Exec(
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE username = $1 AND revoked_at IS NULL",
+		username,
+	)
+	return err
+}
+
+// GetTokenAccess returns the current access of the user an access token was
+// issued to, as GetUserAccess does, and whether the token's login is still
+// active, i.e. its refresh tokens have not been revoked by a logout, a
+// password change or reuse. It returns sql.ErrNoRows if there is no such
+// user.
+func (s *Store) GetTokenAccess(username string, familyID string) (access types.UserAccess, active bool, err error) {
+	err = s.db.QueryRow(`
+		SELECT u.role, u.disabled_at IS NOT NULL, u.session_version,
+			EXISTS(SELECT 1 FROM refresh_tokens t WHERE t.family_id = $2 AND t.username = u.username AND t.revoked_at IS NULL)
+		FROM users u WHERE u.username = $1`,
+		username, familyID,
+	).Scan(&access.Role, &access.Disabled, &access.SessionVersion, &active)
+	return access, active, err
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"speechToText/src/types"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type queryer interface {
+	execer
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// callbackSecret returns the user's secret for per-task callback_url
+// deliveries, generating it if the user has none yet.
+func callbackSecret(q queryer, username string) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	err = q.QueryRow(
+		"UPDATE users SET webhook_secret = COALESCE(webhook_secret, $2) WHERE username = $1 RETURNING webhook_secret",
+		username, secret,
+	).Scan(&secret)
+	return secret, err
+}
+
+// RotateCallbackSecret replaces the user's secret for callback_url
+// deliveries and returns the new one. Deliveries already queued keep the
+// secret they were queued with. It returns sql.ErrNoRows if the user does
+// not exist.
+func (s *Store) RotateCallbackSecret(username string) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	err = s.db.QueryRow(
+		"UPDATE users SET webhook_secret = $2 WHERE username = $1 RETURNING webhook_secret",
+		username, secret,
+	).Scan(&secret)
+	return secret, err
+}
+
+// enqueueWebhookEvent queues a delivery of event for the task to every
+// subscribed webhook of its owner and to the task's callback_url, if any.
+func enqueueWebhookEvent(q queryer, taskID string, event string) error {
+	var username string
+	var status types.TaskStatus
+	var callbackURL, result, errorCode, errorMessage sql.NullString
+	err := q.QueryRow(
+		"SELECT username, status, callback_url, result, error_code, error_message FROM tasks WHERE task_id = $1",
+		taskID,
+	).Scan(&username, &status, &callbackURL, &result, &errorCode, &errorMessage)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(types.WebhookEvent{
+		ID:      uuid.New().String(),
+		Event:   event,
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Task: types.WebhookTask{
+			TaskID:    taskID,
+			Status:    status,
+			Result:    result.String,
+			ErrorCode: errorCode.String,
+			Error:     errorMessage.String,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, username, task_id, event, url, secret, payload)
+		SELECT id, username, $2, $3, url, secret, $4
+		FROM webhooks
+		WHERE username = $1 AND $3 = ANY(events)`,
+		username, taskID, event, payload,
+	); err != nil {
+		return err
+	}
+
+	if callbackURL.String == "" {
+		return nil
+	}
+	secret, err := callbackSecret(q, username)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(
+		`INSERT INTO webhook_deliveries (username, task_id, event, url, secret, payload)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		username, taskID, event, callbackURL.String, secret, payload,
+	)
+	return err
+}
+
+func (s *Store) CreateWebhook(username string, url string, events []string) (types.WebhookInfo, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return types.WebhookInfo{}, err
+	}
+	webhook := types.WebhookInfo{
+		ID:     uuid.New().String(),
+		URL:    url,
+		Events: events,
+		Secret: secret,
+	}
+	var createdAt time.Time
+	err = s.db.QueryRow(
+		"INSERT INTO webhooks (id, username, url, secret, events) VALUES ($1, $2, $3, $4, $5) RETURNING created_at",
+		webhook.ID, username, url, secret, pq.Array(events),
+	).Scan(&createdAt)
+	webhook.Created = createdAt.Format(time.RFC3339)
+	return webhook, err
+}
+
+func (s *Store) ListWebhooks(username string) ([]types.WebhookInfo, error) {
+	rows, err := s.db.Query(
+		"SELECT id, url, events, created_at FROM webhooks WHERE username = $1 ORDER BY created_at",
+		username,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []types.WebhookInfo{}
+	for rows.Next() {
+		var webhook types.WebhookInfo
+		var createdAt time.Time
+		if err := rows.Scan(&webhook.ID, &webhook.URL, pq.Array(&webhook.Events), &createdAt); err != nil {
+			return nil, err
+		}
+		webhook.Created = createdAt.Format(time.RFC3339)
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *Store) DeleteWebhook(id string, username string) error {
+	result, err := s.db.Exec("DELETE FROM webhooks WHERE id = $1 AND username = $2", id, username)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("webhook not found")
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns the user's most recent deliveries, optionally
+// restricted to one task.
+func (s *Store) ListWebhookDeliveries(username string, taskID string, limit int) ([]types.WebhookDelivery, error) {
+	rows, err := s.db.Query(`
+		SELECT id, webhook_id, task_id, event, url, status, attempts, response_code, last_error, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE username = $1 AND ($2 = '' OR task_id = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3`,
+		username, taskID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []types.WebhookDelivery{}
+	for rows.Next() {
+		var delivery types.WebhookDelivery
+		var webhookID, lastError sql.NullString
+		var responseCode sql.NullInt64
+		var createdAt time.Time
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(
+			&delivery.ID, &webhookID, &delivery.TaskID, &delivery.Event, &delivery.URL, &delivery.Status,
+			&delivery.Attempts, &responseCode, &lastError, &createdAt, &deliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		delivery.WebhookID = webhookID.String
+		delivery.ResponseCode = int(responseCode.Int64)
+		delivery.LastError = lastError.String
+		delivery.Created = createdAt.Format(time.RFC3339)
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = deliveredAt.Time.Format(time.RFC3339)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// RedeliverWebhook queues a fresh copy of a past delivery and returns its ID.
+// It returns sql.ErrNoRows if the delivery does not belong to the user.
+func (s *Store) RedeliverWebhook(id int64, username string) (int64, error) {
+	var newID int64
+	err := s.db.QueryRow(`
+		INSERT INTO webhook_deliveries (webhook_id, username, task_id, event, url, secret, payload)
+		SELECT webhook_id, username, task_id, event, url, secret, payload
+		FROM webhook_deliveries
+		WHERE id = $1 AND username = $2
+		RETURNING id`,
+		id, username,
+	).Scan(&newID)
+	return newID, err
+}
+
+// ClaimWebhookDeliveries leases up to limit due deliveries by pushing their
+// next attempt lease into the future, so a crashed dispatcher's claims are
+// retried and concurrent dispatchers never send the same delivery twice.
+func (s *Store) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]types.PendingDelivery, error) {
+	rows, err := s.db.Query(`
+		UPDATE webhook_deliveries
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, url, secret, event, payload, attempts`,
+		limit, lease.Milliseconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []types.PendingDelivery
+	for rows.Next() {
+		var delivery types.PendingDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.URL, &delivery.Secret, &delivery.Event, &delivery.Payload, &delivery.Attempts); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *Store) MarkWebhookDelivered(id int64, responseCode int) error {
+	_, err := s.db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = 'delivered', attempts = attempts + 1, response_code = $2, last_error = NULL, delivered_at = NOW()
+		WHERE id = $1`,
+		id, responseCode,
+	)
+	return err
+}
+
+// MarkWebhookAttemptFailed records a failed attempt. A zero retryIn gives up
+// on the delivery.
+func (s *Store) MarkWebhookAttemptFailed(id int64, responseCode int, reason string, retryIn time.Duration) error {
+	status := "pending"
+	if retryIn <= 0 {
+		status = "failed"
+	}
+	_, err := s.db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, response_code = NULLIF($3, 0), last_error = $4,
+			next_attempt_at = NOW() + $5 * INTERVAL '1 millisecond'
+		WHERE id = $1`,
+		id, status, responseCode, reason, retryIn.Milliseconds(),
+	)
+	return err
+}
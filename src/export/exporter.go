@@ -0,0 +1,129 @@
+package export
+
+import (
+	"context"
+	"io"
+	"speechToText/src/config"
+	"speechToText/src/db"
+	"speechToText/src/service"
+	"speechToText/src/storage"
+	"speechToText/src/types"
+	"time"
+)
+
+// Key is the storage key of an export's archive.
+func Key(exportID string, format string) string {
+	return exportID + "." + format
+}
+
+// Exporter runs pending export jobs and removes expired archives.
+type Exporter struct {
+	store     *db.Store
+	files     storage.Storage
+	interval  time.Duration
+	retention time.Duration
+	stale     time.Duration
+}
+
+func NewExporter(store *db.Store, files storage.Storage, cfg *config.ExportConfig) *Exporter {
+	return &Exporter{
+		store:     store,
+		files:     files,
+		interval:  cfg.PollInterval,
+		retention: cfg.Retention,
+		stale:     cfg.StaleAfter,
+	}
+}
+
+// Run picks up pending exports every interval until ctx is cancelled.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.flush(ctx)
+		case <-purge.C:
+			e.purge()
+		}
+	}
+}
+
+// flush runs pending exports one at a time until none are left.
+func (e *Exporter) flush(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := e.store.ClaimExport(e.stale)
+		if err != nil {
+			service.LogError("exporter: claim: %v", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+		if err := e.run(job); err != nil {
+			service.LogError("exporter: export %s: %v", job.ExportID, err)
+			_ = e.files.Remove(Key(job.ExportID, job.Format))
+			if err := e.store.FailExport(job.ExportID, err.Error()); err != nil {
+				service.LogError("exporter: mark export %s failed: %v", job.ExportID, err)
+			}
+		}
+	}
+}
+
+func (e *Exporter) run(job *types.ExportJob) error {
+	filter, err := job.Filter.TaskFilter()
+	if err != nil {
+		return err
+	}
+
+	f, err := e.files.Create(Key(job.ExportID, job.Format))
+	if err != nil {
+		return err
+	}
+	w := &countingWriter{w: f}
+	count, err := Write(w, job.Format, job.Files, func(fn func(types.ExportRow) error) error {
+		return e.store.StreamExportTasks(job.Username, filter, fn)
+	})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return e.store.CompleteExport(job.ExportID, count, w.n, time.Now().Add(e.retention))
+}
+
+// purge deletes expired exports and their archives.
+func (e *Exporter) purge() {
+	exports, err := e.store.ExpiredExports(100)
+	if err != nil {
+		service.LogError("exporter: list expired: %v", err)
+		return
+	}
+	for _, info := range exports {
+		if err := e.files.Remove(Key(info.ExportID, info.Format)); err != nil {
+			service.LogError("exporter: remove export %s: %v", info.ExportID, err)
+			continue
+		}
+		if err := e.store.DeleteExport(info.ExportID); err != nil {
+			service.LogError("exporter: delete export %s: %v", info.ExportID, err)
+		}
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,139 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"speechToText/src/types"
+	"strings"
+)
+
+// segments returns the row's subtitle segments. Tasks transcribed without
+// word timings get a single segment spanning the whole audio.
+func segments(row types.ExportRow) []types.Segment {
+	if len(row.Segments) > 0 {
+		return row.Segments
+	}
+	end := row.Duration
+	if end <= 0 {
+		end = 1
+	}
+	return []types.Segment{{Start: 0, End: end, Text: row.Result}}
+}
+
+// timestamp formats seconds as HH:MM:SS followed by sep and milliseconds.
+func timestamp(seconds float64, sep string) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, sep, ms%1000)
+}
+
+// WriteSRT writes the row's transcript as SubRip subtitles.
+func WriteSRT(w io.Writer, row types.ExportRow) error {
+	for i, segment := range segments(row) {
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n",
+			i+1, timestamp(segment.Start, ","), timestamp(segment.End, ","), segment.Text,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteVTT writes the row's transcript as WebVTT subtitles.
+func WriteVTT(w io.Writer, row types.ExportRow) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	for _, segment := range segments(row) {
+		if _, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n",
+			timestamp(segment.Start, "."), timestamp(segment.End, "."), segment.Text,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var csvHeader = []string{"task_id", "language", "tags", "created", "finished_at", "duration", "result"}
+
+func csvRecord(row types.ExportRow) []string {
+	duration := ""
+	if row.Duration > 0 {
+		duration = fmt.Sprintf("%.3f", row.Duration)
+	}
+	return []string{
+		row.TaskID, row.Language, strings.Join(row.Tags, ";"), row.Created, row.FinishedAt, duration, row.Result,
+	}
+}
+
+// writeFile writes the row as a single file of the given type.
+func writeFile(w io.Writer, fileType string, row types.ExportRow) error {
+	switch fileType {
+	case types.ExportFileTXT:
+		_, err := io.WriteString(w, row.Result+"\n")
+		return err
+	case types.ExportFileSRT:
+		return WriteSRT(w, row)
+	case types.ExportFileVTT:
+		return WriteVTT(w, row)
+	case types.ExportFileJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(row)
+	}
+	return fmt.Errorf("unknown file type %q", fileType)
+}
+
+// Write encodes the rows produced by each into w in the given format, with
+// one file per requested file type and task for ZIP archives. It returns the
+// number of rows written.
+func Write(w io.Writer, format string, files []string, each func(func(types.ExportRow) error) error) (int, error) {
+	count := 0
+	switch format {
+	case types.ExportJSONL:
+		encoder := json.NewEncoder(w)
+		err := each(func(row types.ExportRow) error {
+			count++
+			return encoder.Encode(row)
+		})
+		return count, err
+
+	case types.ExportCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return 0, err
+		}
+		err := each(func(row types.ExportRow) error {
+			count++
+			return writer.Write(csvRecord(row))
+		})
+		writer.Flush()
+		if err == nil {
+			err = writer.Error()
+		}
+		return count, err
+
+	case types.ExportZIP:
+		archive := zip.NewWriter(w)
+		err := each(func(row types.ExportRow) error {
+			count++
+			for _, fileType := range files {
+				f, err := archive.Create(row.TaskID + "." + fileType)
+				if err != nil {
+					return err
+				}
+				if err := writeFile(f, fileType, row); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if closeErr := archive.Close(); err == nil {
+			err = closeErr
+		}
+		return count, err
+	}
+	return 0, fmt.Errorf("unknown export format %q", format)
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// LoginMetrics counts failed logins, lockouts by scope (username or ip) and
+// attempts rejected while locked out or delayed.
+type LoginMetrics struct {
+	Failures  prometheus.Counter
+	Lockouts  *prometheus.CounterVec
+	Throttled prometheus.Counter
+}
+
+func NewLoginMetrics() *LoginMetrics {
+	failures := prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "login_failures_total"},
+	)
+
+	lockouts := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "login_lockouts_total"},
+		[]string{"scope"},
+	)
+
+	throttled := prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "login_throttled_total"},
+	)
+
+	prometheus.MustRegister(failures, lockouts, throttled)
+
+	return &LoginMetrics{
+		Failures:  failures,
+		Lockouts:  lockouts,
+		Throttled: throttled,
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// WorkerMetrics describes the consumer's worker pool, labelled by worker
+// index, and its broker connection.
+type WorkerMetrics struct {
+	Tasks      *prometheus.CounterVec
+	Duration   *prometheus.HistogramVec
+	Busy       *prometheus.GaugeVec
+	Connected  prometheus.Gauge
+	Reconnects prometheus.Counter
+}
+
+func NewWorkerMetrics() *WorkerMetrics {
+	tasks := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "worker_tasks_total"},
+		[]string{"worker", "outcome"},
+	)
+
+	duration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "worker_task_duration_seconds",
+			Buckets: []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"worker"},
+	)
+
+	busy := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "worker_busy"},
+		[]string{"worker"},
+	)
+
+	connected := prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "rabbitmq_consumer_connected"},
+	)
+
+	reconnects := prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "rabbitmq_consumer_reconnects_total"},
+	)
+
+	prometheus.MustRegister(tasks, duration, busy, connected, reconnects)
+
+	return &WorkerMetrics{
+		Tasks:      tasks,
+		Duration:   duration,
+		Busy:       busy,
+		Connected:  connected,
+		Reconnects: reconnects,
+	}
+}
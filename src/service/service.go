@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"speechToText/src/types"
+	"strings"
 )
 
 func LogDebug(format string, args ...interface{}) {
@@ -30,11 +31,61 @@ func ReadAuthRequest(r *http.Request) (types.AuthRequest, error) {
 	if err = json.Unmarshal(data, &authData); err != nil {
 		return types.AuthRequest{}, err
 	}
+	authData.Username = NormalizeUsername(authData.Username)
 	if authData.Username == "" || authData.Password == "" {
 		return types.AuthRequest{}, fmt.Errorf("username and password are required")
 	}
-	if len(authData.Password) < 8 {
-		return types.AuthRequest{}, fmt.Errorf("password must be at least 8 characters")
+	if err = ValidatePassword(authData.Password); err != nil {
+		return types.AuthRequest{}, err
 	}
 	return authData, nil
 }
+
+// NormalizeUsername trims and lowercases a username, so that "Alice" and
+// "alice" name the same account.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func ValidatePassword(password string) error {
+	if len(password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+	return nil
+}
+
+// DeviceName describes the browser and operating system of a user agent,
+// e.g. "Firefox on Linux".
+func DeviceName(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"PostmanRuntime/", "Postman"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, os := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, os.token) {
+			return browser + " on " + os.name
+		}
+	}
+	return browser
+}
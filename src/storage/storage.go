@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage keeps generated files, such as exports, under string keys.
+type Storage interface {
+	// Create returns a writer for key. The file becomes visible to Open only
+	// once the writer is closed successfully.
+	Create(key string) (io.WriteCloser, error)
+	Open(key string) (io.ReadSeekCloser, error)
+	Remove(key string) error
+}
+
+// Local stores files in a directory. Instances that share the directory,
+// e.g. through a volume, see each other's files.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", errors.New("invalid storage key")
+	}
+	return filepath.Join(l.dir, key), nil
+}
+
+func (l *Local) Create(key string) (io.WriteCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(l.dir, "."+key+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &pendingFile{File: f, path: path}, nil
+}
+
+func (l *Local) Open(key string) (io.ReadSeekCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (l *Local) Remove(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// pendingFile is a temporary file renamed into place on Close.
+type pendingFile struct {
+	*os.File
+	path string
+}
+
+func (f *pendingFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), f.path)
+}
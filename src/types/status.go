@@ -0,0 +1,74 @@
+package types
+
+import "slices"
+
+// TaskStatus is a step of the task lifecycle:
+//
+//	queued → downloading → transcribing → post_processing → completed
+//
+// A task whose source was already transcribed goes straight from downloading
+// to completed with the cached transcript.
+// Any unfinished status can move to failed or cancelled, active statuses can
+// go back to queued when a stuck task is requeued, and failed tasks can be
+// queued again by a retry.
+type TaskStatus string
+
+const (
+	StatusQueued         TaskStatus = "queued"
+	StatusDownloading    TaskStatus = "downloading"
+	StatusTranscribing   TaskStatus = "transcribing"
+	StatusPostProcessing TaskStatus = "post_processing"
+	StatusCompleted      TaskStatus = "completed"
+	StatusFailed         TaskStatus = "failed"
+	StatusCancelled      TaskStatus = "cancelled"
+)
+
+var taskTransitions = map[TaskStatus][]TaskStatus{
+	StatusQueued:         {StatusDownloading, StatusFailed, StatusCancelled},
+	StatusDownloading:    {StatusTranscribing, StatusCompleted, StatusQueued, StatusFailed, StatusCancelled},
+	StatusTranscribing:   {StatusPostProcessing, StatusQueued, StatusFailed, StatusCancelled},
+	StatusPostProcessing: {StatusCompleted, StatusQueued, StatusFailed, StatusCancelled},
+	StatusFailed:         {StatusQueued},
+}
+
+// TaskStatuses lists every status in lifecycle order.
+var TaskStatuses = []TaskStatus{
+	StatusQueued, StatusDownloading, StatusTranscribing, StatusPostProcessing,
+	StatusCompleted, StatusFailed, StatusCancelled,
+}
+
+func (s TaskStatus) CanTransitionTo(next TaskStatus) bool {
+	return slices.Contains(taskTransitions[s], next)
+}
+
+// IsFinal reports whether the task has finished, successfully or not.
+func (s TaskStatus) IsFinal() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled
+}
+
+// IsActive reports whether a worker is processing the task.
+func (s TaskStatus) IsActive() bool {
+	return s == StatusDownloading || s == StatusTranscribing || s == StatusPostProcessing
+}
+
+// TransitionsInto returns the statuses from which a task may move to next.
+func TransitionsInto(next TaskStatus) []TaskStatus {
+	var from []TaskStatus
+	for _, status := range TaskStatuses {
+		if status.CanTransitionTo(next) {
+			from = append(from, status)
+		}
+	}
+	return from
+}
+
+// UnfinishedStatuses returns the statuses of tasks that are queued or running.
+func UnfinishedStatuses() []TaskStatus {
+	var statuses []TaskStatus
+	for _, status := range TaskStatuses {
+		if !status.IsFinal() {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
@@ -1,19 +1,118 @@
 package types
 
-import amqp "github.com/rabbitmq/amqp091-go"
+import (
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
 
 type AuthRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Mode selects what /login returns: a session cookie (LoginSession, the
+	// default) or an access and refresh token pair (LoginToken).
+	Mode string `json:"mode,omitempty"`
+}
+
+// Login modes.
+const (
+	LoginSession = "session"
+	LoginToken   = "token"
+)
+
+// TokenResponse is returned by token logins and refreshes. ExpiresIn and
+// RefreshExpiresIn are in seconds.
+type TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TranscriptionOptions are passed through to the speech recognition provider.
+// Empty fields fall back to the service defaults.
+type TranscriptionOptions struct {
+	Model    string `json:"model,omitempty"`
+	Language string `json:"language,omitempty"`
 }
 
 type AudioRequest struct {
-	Audio string `json:"audio"`
+	Audio       string   `json:"audio"`
+	CallbackURL string   `json:"callback_url,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// NoCache forces a new transcription even if the same source was
+	// already transcribed with the same options.
+	NoCache bool `json:"no_cache,omitempty"`
+	TranscriptionOptions
 }
 
 type AudioMessage struct {
-	Audio  string `json:"audio"`
-	TaskID string `json:"task_id"`
+	Audio   string `json:"audio"`
+	TaskID  string `json:"task_id"`
+	NoCache bool   `json:"no_cache,omitempty"`
+	TranscriptionOptions
+}
+
+// BatchRequest submits many sources at once. Fields left empty on an item
+// are taken from Defaults.
+type BatchRequest struct {
+	Items    []AudioRequest `json:"items"`
+	Defaults AudioRequest   `json:"defaults"`
+}
+
+type BatchResponse struct {
+	BatchID string   `json:"batch_id"`
+	TaskIDs []string `json:"task_ids"`
+}
+
+type BatchTask struct {
+	TaskID string     `json:"task_id"`
+	Audio  string     `json:"audio"`
+	Status TaskStatus `json:"status"`
+}
+
+type BatchStatusResponse struct {
+	BatchID string             `json:"batch_id"`
+	Created string             `json:"created"`
+	Total   int                `json:"total"`
+	Done    bool               `json:"done"`
+	Counts  map[TaskStatus]int `json:"counts"`
+	Tasks   []BatchTask        `json:"tasks"`
+}
+
+type BatchExportItem struct {
+	TaskID    string     `json:"task_id"`
+	Audio     string     `json:"audio"`
+	Status    TaskStatus `json:"status"`
+	Result    string     `json:"result,omitempty"`
+	ErrorCode string     `json:"error_code,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type BatchExportResponse struct {
+	BatchID string            `json:"batch_id"`
+	Items   []BatchExportItem `json:"items"`
+}
+
+type OutboxMessage struct {
+	ID       int64
+	TaskID   string
+	Queue    string
+	Payload  []byte
+	Attempts int
+}
+
+// ReapResult lists the tasks one reaper pass acted on.
+type ReapResult struct {
+	Requeued    []string
+	Failed      []string
+	Republished []string
 }
 
 type QueueRabbitMQ struct {
@@ -27,13 +126,39 @@ type GetInfoResponse struct {
 }
 
 type GetResultResponse struct {
-	Result string `json:"result"`
+	Result    string `json:"result"`
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
 type GetStatusResponse struct {
-	Status string `json:"status"`
+	Status         TaskStatus `json:"status"`
+	ErrorCode      string     `json:"error_code,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	QueuedAt       string     `json:"queued_at,omitempty"`
+	StartedAt      string     `json:"started_at,omitempty"`
+	FinishedAt     string     `json:"finished_at,omitempty"`
+	AudioExpiresAt string     `json:"audio_expires_at,omitempty"`
+	ExpiresAt      string     `json:"expires_at,omitempty"`
+	// CachedFrom is the task whose transcript was reused instead of
+	// transcribing the source again.
+	CachedFrom string `json:"cached_from,omitempty"`
 }
 
+// Machine-readable reasons a task failed, returned as error_code.
+const (
+	ErrorSourceUnreachable = "source_unreachable"
+	ErrorSourceBlocked     = "source_blocked"
+	ErrorUnsupportedFormat = "unsupported_format"
+	ErrorProviderTimeout   = "provider_timeout"
+	ErrorProviderAuth      = "provider_auth"
+	ErrorProviderRejected  = "provider_rejected"
+	ErrorProviderError     = "provider_error"
+	ErrorEmptyResult       = "empty_result"
+	ErrorWorkerTimeout     = "worker_timeout"
+	ErrorInternal          = "internal"
+)
+
 type PaginationRequest struct {
 	Page     int `json:"page" form:"page" binding:"min=1"`
 	PageSize int `json:"page_size" form:"page_size" binding:"min=1,max=100"`
@@ -46,14 +171,530 @@ type PaginationResponse struct {
 	TotalPages int   `json:"total_pages"`
 }
 
+// TaskListResponse is a page of tasks. Pagination is only set for
+// page-numbered requests; NextCursor is set whenever more tasks follow.
 type TaskListResponse struct {
-	Tasks      []TaskInfo         `json:"tasks"`
-	Pagination PaginationResponse `json:"pagination"`
+	Tasks      []TaskInfo          `json:"tasks"`
+	Pagination *PaginationResponse `json:"pagination,omitempty"`
+	NextCursor string              `json:"next_cursor,omitempty"`
 }
 
 type TaskInfo struct {
+	TaskID     string     `json:"task_id"`
+	Username   string     `json:"username"`
+	Status     TaskStatus `json:"status"`
+	Language   string     `json:"language,omitempty"`
+	Tags       []string   `json:"tags"`
+	ErrorCode  string     `json:"error_code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Created    string     `json:"created"`
+	QueuedAt   string     `json:"queued_at,omitempty"`
+	StartedAt  string     `json:"started_at,omitempty"`
+	FinishedAt string     `json:"finished_at,omitempty"`
+	// AudioExpiresAt is when the source URL is erased and ExpiresAt when the
+	// task and its transcript are deleted; empty means never.
+	AudioExpiresAt string `json:"audio_expires_at,omitempty"`
+	ExpiresAt      string `json:"expires_at,omitempty"`
+	CachedFrom     string `json:"cached_from,omitempty"`
+}
+
+// Task list sort fields. Ties are broken by creation time, then task ID.
+const (
+	SortCreatedAt = "created_at"
+	SortStatus    = "status"
+	SortLanguage  = "language"
+)
+
+var TaskSortFields = []string{SortCreatedAt, SortStatus, SortLanguage}
+
+// TaskQuery selects a page of a user's tasks. Pages continue after After if
+// it is set, otherwise they start at Offset.
+type TaskQuery struct {
+	Filter TaskFilter
+	Sort   string
+	Desc   bool
+	After  *TaskCursor
+	Offset int
+}
+
+// TaskCursor is the position of the last task of a page in its sort order.
+type TaskCursor struct {
+	Sort    string    `json:"s"`
+	Key     string    `json:"k,omitempty"`
+	Created time.Time `json:"c"`
+	TaskID  string    `json:"id"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+// WebhookInfo describes a webhook endpoint. Secret is only returned when the
+// webhook is created.
+type WebhookInfo struct {
+	ID      string   `json:"id"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Secret  string   `json:"secret,omitempty"`
+	Created string   `json:"created"`
+}
+
+type WebhookListResponse struct {
+	Webhooks []WebhookInfo `json:"webhooks"`
+}
+
+type CallbackSecretResponse struct {
+	Secret string `json:"secret"`
+}
+
+type WebhookDelivery struct {
+	ID           int64  `json:"id"`
+	WebhookID    string `json:"webhook_id,omitempty"`
+	TaskID       string `json:"task_id"`
+	Event        string `json:"event"`
+	URL          string `json:"url"`
+	Status       string `json:"status"`
+	Attempts     int    `json:"attempts"`
+	ResponseCode int    `json:"response_code,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	Created      string `json:"created"`
+	DeliveredAt  string `json:"delivered_at,omitempty"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookEvent is the JSON body POSTed to webhook endpoints.
+type WebhookEvent struct {
+	ID      string      `json:"id"`
+	Event   string      `json:"event"`
+	Created string      `json:"created"`
+	Task    WebhookTask `json:"task"`
+}
+
+type WebhookTask struct {
+	TaskID    string     `json:"task_id"`
+	Status    TaskStatus `json:"status"`
+	Result    string     `json:"result,omitempty"`
+	ErrorCode string     `json:"error_code,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type PendingDelivery struct {
+	ID       int64
+	URL      string
+	Secret   string
+	Event    string
+	Payload  []byte
+	Attempts int
+}
+
+const (
+	EventTaskCompleted = "task.completed"
+	EventTaskFailed    = "task.failed"
+	EventTaskCancelled = "task.cancelled"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to.
+var WebhookEvents = []string{EventTaskCompleted, EventTaskFailed, EventTaskCancelled}
+
+// TaskEvent is a task status change pushed to SSE subscribers.
+type TaskEvent struct {
+	ID        string     `json:"-"`
+	Username  string     `json:"-"`
+	TaskID    string     `json:"task_id"`
+	Status    TaskStatus `json:"status"`
+	ErrorCode string     `json:"error_code,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Time      string     `json:"time"`
+}
+
+type RetryRequest struct {
+	TranscriptionOptions
+}
+
+// BulkRetryRequest selects failed tasks to retry. All filters are optional
+// and combined with AND; timestamps are RFC 3339.
+type BulkRetryRequest struct {
+	TaskIDs       []string `json:"task_ids,omitempty"`
+	BatchID       string   `json:"batch_id,omitempty"`
+	CreatedAfter  string   `json:"created_after,omitempty"`
+	CreatedBefore string   `json:"created_before,omitempty"`
+	Limit         int      `json:"limit,omitempty"`
+	TranscriptionOptions
+}
+
+// TaskFilter narrows a query over a user's tasks. Zero values match all.
+type TaskFilter struct {
+	TaskIDs       []string
+	BatchID       string
+	Statuses      []TaskStatus
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Language      string
+	Tag           string
+	TextPrefix    string
+	Limit         int
+}
+
+type BulkRetryResponse struct {
+	TaskIDs []string `json:"task_ids"`
+}
+
+type TaskAttempt struct {
+	Attempt    int        `json:"attempt"`
+	Status     TaskStatus `json:"status"`
+	ErrorCode  string     `json:"error_code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Model      string     `json:"model,omitempty"`
+	Language   string     `json:"language,omitempty"`
+	QueuedAt   string     `json:"queued_at,omitempty"`
+	StartedAt  string     `json:"started_at,omitempty"`
+	FinishedAt string     `json:"finished_at,omitempty"`
+}
+
+type TaskAttemptsResponse struct {
+	TaskID   string        `json:"task_id"`
+	Attempt  int           `json:"attempt"`
+	Attempts []TaskAttempt `json:"attempts"`
+}
+
+// RetentionSettings are a user's retention periods in days. A nil field uses
+// the service default and zero keeps the data forever.
+type RetentionSettings struct {
+	AudioDays      *int `json:"audio_days"`
+	TranscriptDays *int `json:"transcript_days"`
+}
+
+type RetentionResponse struct {
+	RetentionSettings
+	DefaultAudioDays      int `json:"default_audio_days"`
+	DefaultTranscriptDays int `json:"default_transcript_days"`
+}
+
+// Kinds of purge recorded in the purge log.
+const (
+	PurgeAudio = "audio"
+	PurgeTask  = "task"
+)
+
+type PurgeRecord struct {
 	TaskID   string `json:"task_id"`
-	Username string `json:"username"`
-	Status   string `json:"status"`
-	Created  string `json:"created"`
+	Kind     string `json:"kind"`
+	PurgedAt string `json:"purged_at"`
+}
+
+type PurgeListResponse struct {
+	Purges []PurgeRecord `json:"purges"`
+}
+
+// Segment is a timed piece of a transcript, used for subtitles. Times are in
+// seconds from the start of the audio.
+type Segment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+type Transcript struct {
+	Text     string
+	Duration float64
+	Segments []Segment
+}
+
+// Export formats. ZIP archives hold one file per task and requested file
+// type.
+const (
+	ExportJSONL = "jsonl"
+	ExportCSV   = "csv"
+	ExportZIP   = "zip"
+
+	ExportFileTXT  = "txt"
+	ExportFileSRT  = "srt"
+	ExportFileVTT  = "vtt"
+	ExportFileJSON = "json"
+)
+
+var (
+	ExportFormats   = []string{ExportJSONL, ExportCSV, ExportZIP}
+	ExportFileTypes = []string{ExportFileTXT, ExportFileSRT, ExportFileVTT, ExportFileJSON}
+)
+
+// Export job statuses.
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+// ExportFilter selects the completed tasks to export. Empty fields match all.
+type ExportFilter struct {
+	TaskIDs       []string `json:"task_ids,omitempty"`
+	BatchID       string   `json:"batch_id,omitempty"`
+	CreatedAfter  string   `json:"created_after,omitempty"`
+	CreatedBefore string   `json:"created_before,omitempty"`
+	Language      string   `json:"language,omitempty"`
+	Tag           string   `json:"tag,omitempty"`
+}
+
+type ExportRequest struct {
+	Format string       `json:"format"`
+	Files  []string     `json:"files,omitempty"`
+	Filter ExportFilter `json:"filter"`
+}
+
+type ExportInfo struct {
+	ExportID    string       `json:"export_id"`
+	Username    string       `json:"-"`
+	Status      string       `json:"status"`
+	Format      string       `json:"format"`
+	Files       []string     `json:"files,omitempty"`
+	Filter      ExportFilter `json:"filter"`
+	TaskCount   int          `json:"task_count"`
+	Size        int64        `json:"size"`
+	Error       string       `json:"error,omitempty"`
+	Created     string       `json:"created"`
+	FinishedAt  string       `json:"finished_at,omitempty"`
+	ExpiresAt   string       `json:"expires_at,omitempty"`
+	DownloadURL string       `json:"download_url,omitempty"`
+	LinkExpires string       `json:"link_expires_at,omitempty"`
+}
+
+// TaskFilter converts the filter to a query over completed tasks.
+func (f ExportFilter) TaskFilter() (TaskFilter, error) {
+	filter := TaskFilter{
+		TaskIDs:  f.TaskIDs,
+		BatchID:  f.BatchID,
+		Statuses: []TaskStatus{StatusCompleted},
+		Language: f.Language,
+		Tag:      f.Tag,
+	}
+	var err error
+	if f.CreatedAfter != "" {
+		if filter.CreatedAfter, err = time.Parse(time.RFC3339, f.CreatedAfter); err != nil {
+			return filter, fmt.Errorf("invalid created_after: must be an RFC 3339 timestamp")
+		}
+	}
+	if f.CreatedBefore != "" {
+		if filter.CreatedBefore, err = time.Parse(time.RFC3339, f.CreatedBefore); err != nil {
+			return filter, fmt.Errorf("invalid created_before: must be an RFC 3339 timestamp")
+		}
+	}
+	return filter, nil
+}
+
+// ExportJob is a claimed export ready to be run.
+type ExportJob struct {
+	ExportID string
+	Username string
+	Format   string
+	Files    []string
+	Filter   ExportFilter
+}
+
+// ExportRow is one exported task.
+type ExportRow struct {
+	TaskID     string    `json:"task_id"`
+	Language   string    `json:"language,omitempty"`
+	Tags       []string  `json:"tags"`
+	Created    string    `json:"created"`
+	FinishedAt string    `json:"finished_at,omitempty"`
+	Duration   float64   `json:"duration,omitempty"`
+	Result     string    `json:"result"`
+	Segments   []Segment `json:"segments,omitempty"`
+}
+
+// API key scopes. Requests authenticated with a session cookie have every
+// scope.
+const (
+	ScopeTasksRead     = "tasks:read"
+	ScopeTasksWrite    = "tasks:write"
+	ScopeTasksDelete   = "tasks:delete"
+	ScopeWebhooksRead  = "webhooks:read"
+	ScopeWebhooksWrite = "webhooks:write"
+	ScopeSettingsRead  = "settings:read"
+	ScopeSettingsWrite = "settings:write"
+)
+
+// APIKeyScopes lists every scope an API key can be granted.
+var APIKeyScopes = []string{
+	ScopeTasksRead, ScopeTasksWrite, ScopeTasksDelete,
+	ScopeWebhooksRead, ScopeWebhooksWrite,
+	ScopeSettingsRead, ScopeSettingsWrite,
+}
+
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// APIKeyInfo describes an API key. The key itself is only returned once, on
+// creation; Prefix identifies it afterwards.
+type APIKeyInfo struct {
+	ID         string   `json:"id"`
+	Username   string   `json:"-"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	Key        string   `json:"key,omitempty"`
+	Created    string   `json:"created"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+}
+
+type APIKeyListResponse struct {
+	Keys []APIKeyInfo `json:"keys"`
+}
+
+// User roles. Support staff can inspect users and tasks and requeue tasks;
+// admins can also disable accounts, change roles and read the audit log.
+const (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+)
+
+// Roles lists every role a user can have.
+var Roles = []string{RoleUser, RoleAdmin, RoleSupport}
+
+type UserInfo struct {
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	Created    string `json:"created"`
+	DisabledAt string `json:"disabled_at,omitempty"`
+	Tasks      int64  `json:"tasks"`
+}
+
+// UserQuery selects users for the admin user list. Search matches part of
+// the username, case-insensitively.
+type UserQuery struct {
+	Search string
+	Role   string
+	Limit  int
+	Offset int
+}
+
+type UserListResponse struct {
+	Users []UserInfo `json:"users"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AdminTask is a task of any user with the details support needs to debug
+// it.
+type AdminTask struct {
+	TaskInfo
+	Audio       string `json:"audio,omitempty"`
+	Model       string `json:"model,omitempty"`
+	NoCache     bool   `json:"no_cache,omitempty"`
+	Attempt     int    `json:"attempt"`
+	Requeues    int    `json:"requeues"`
+	HeartbeatAt string `json:"heartbeat_at,omitempty"`
+	Result      string `json:"result,omitempty"`
+}
+
+// TaskStats are system-wide task counts. The Last24h figures cover tasks
+// created, completed and failed in the last 24 hours; AvgProcessingSeconds
+// is the mean time from start to finish of the tasks completed in that
+// window.
+type TaskStats struct {
+	ByStatus             map[TaskStatus]int64 `json:"by_status"`
+	CreatedLast24h       int64                `json:"created_last_24h"`
+	CompletedLast24h     int64                `json:"completed_last_24h"`
+	FailedLast24h        int64                `json:"failed_last_24h"`
+	AvgProcessingSeconds float64              `json:"avg_processing_seconds"`
+	PendingOutbox        int64                `json:"pending_outbox"`
+	Users                int64                `json:"users"`
+	DisabledUsers        int64                `json:"disabled_users"`
+}
+
+// Audit log actions.
+const (
+	AuditListUsers   = "users.list"
+	AuditDisableUser = "user.disable"
+	AuditEnableUser  = "user.enable"
+	AuditSetRole     = "user.set_role"
+	AuditViewTask    = "task.view"
+	AuditRequeueTask = "task.requeue"
+	AuditViewStats   = "stats.view"
+	AuditViewLog     = "audit.view"
+	AuditLoginLocked = "login.lockout"
+)
+
+type AuditEntry struct {
+	ID      int64          `json:"id"`
+	Actor   string         `json:"actor"`
+	Action  string         `json:"action"`
+	Target  string         `json:"target,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+	Created string         `json:"created"`
+}
+
+type AuditLogResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// UserAccess is what authenticating a request needs to know about the user.
+// SessionVersion changes with the password; sessions started before that no
+// longer work.
+type UserAccess struct {
+	Role           string
+	Disabled       bool
+	SessionVersion string
+}
+
+type UsageSummary struct {
+	Tasks         int64                `json:"tasks"`
+	ByStatus      map[TaskStatus]int64 `json:"by_status"`
+	TasksLast30d  int64                `json:"tasks_last_30_days"`
+	AudioSeconds  float64              `json:"audio_seconds"`
+	LastTaskAt    string               `json:"last_task_at,omitempty"`
+	ActiveAPIKeys int64                `json:"active_api_keys"`
+	Webhooks      int64                `json:"webhooks"`
+	StoredExports int64                `json:"stored_exports"`
+}
+
+type ProfileResponse struct {
+	Username string       `json:"username"`
+	Role     string       `json:"role"`
+	Created  string       `json:"created_at"`
+	Usage    UsageSummary `json:"usage"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// DeleteAccountRequest confirms the deletion of the account with its
+// password.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// SessionInfo describes a login session. ID is a handle derived from the
+// session, not the session cookie itself.
+type SessionInfo struct {
+	ID         string `json:"id"`
+	Current    bool   `json:"current"`
+	Device     string `json:"device"`
+	IP         string `json:"ip,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	Created    string `json:"created"`
+	LastSeenAt string `json:"last_seen_at"`
+}
+
+type SessionListResponse struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// RevokeSessionsResponse reports how many sessions "log out everywhere"
+// ended.
+type RevokeSessionsResponse struct {
+	Revoked int `json:"revoked"`
 }
@@ -0,0 +1,271 @@
+// Package urlpolicy decides which URLs the service may fetch or call on a
+// user's behalf: audio sources as well as webhook and callback endpoints. It
+// rejects destinations on internal networks so that submitted URLs cannot be
+// used to reach services behind the firewall.
+package urlpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"speechToText/src/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBlocked is wrapped by every error reporting a destination the policy
+// does not allow.
+var ErrBlocked = errors.New("destination not allowed")
+
+const maxRedirects = 5
+
+// reserved lists ranges that are not covered by the netip predicates but
+// must not be reachable either: shared and benchmarking space, documentation
+// and reserved ranges, and IPv6 prefixes that embed IPv4 addresses.
+var reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+	// Azure's metadata and DNS endpoint sits in public space.
+	netip.MustParsePrefix("168.63.129.16/32"),
+}
+
+// Policy checks URLs against the allowed ports, the host allow and deny
+// lists and the blocked address ranges.
+type Policy struct {
+	ports      map[int]bool
+	allowHosts []string
+	denyHosts  []string
+	allowNets  []netip.Prefix
+	denyNets   []netip.Prefix
+	timeout    time.Duration
+	resolver   *net.Resolver
+}
+
+// New builds a policy from cfg. Host list entries are host names, with a
+// leading "*." or "." matching subdomains, IP addresses or CIDR ranges.
+func New(cfg *config.URLPolicyConfig) *Policy {
+	p := &Policy{
+		ports:    make(map[int]bool, len(cfg.AllowedPorts)),
+		timeout:  cfg.ResolveTimeout,
+		resolver: net.DefaultResolver,
+	}
+	for _, port := range cfg.AllowedPorts {
+		p.ports[port] = true
+	}
+	p.allowHosts, p.allowNets = splitHosts(cfg.AllowHosts)
+	p.denyHosts, p.denyNets = splitHosts(cfg.DenyHosts)
+	return p
+}
+
+func splitHosts(entries []string) ([]string, []netip.Prefix) {
+	var hosts []string
+	var nets []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			nets = append(nets, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			nets = append(nets, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else if entry != "" {
+			hosts = append(hosts, strings.TrimPrefix(entry, "*"))
+		}
+	}
+	return hosts, nets
+}
+
+func matchHost(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if host == pattern || (strings.HasPrefix(pattern, ".") && strings.HasSuffix(host, pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAddr(addr netip.Addr, nets []netip.Prefix) bool {
+	for _, prefix := range nets {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocked reports whether addr is on a loopback, private, link-local,
+// multicast or otherwise non-public network, including cloud metadata
+// endpoints.
+func Blocked(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return true
+	}
+	return matchAddr(addr, reserved) || addr == netip.AddrFrom4([4]byte{255, 255, 255, 255})
+}
+
+// numericHost reports whether host is an IP address in a notation netip
+// does not parse, such as "2130706433" or "0x7f.1", which some clients still
+// resolve to an address. Real top-level domains are never numeric.
+func numericHost(host string) bool {
+	labels := strings.Split(host, ".")
+	last := labels[len(labels)-1]
+	if strings.HasPrefix(last, "0x") {
+		return true
+	}
+	_, err := strconv.ParseUint(last, 10, 64)
+	return err == nil
+}
+
+func (p *Policy) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	if matchAddr(addr, p.denyNets) {
+		return fmt.Errorf("%w: address %s is denied", ErrBlocked, addr)
+	}
+	if !matchAddr(addr, p.allowNets) && Blocked(addr) {
+		return fmt.Errorf("%w: address %s is not public", ErrBlocked, addr)
+	}
+	return nil
+}
+
+// addresses returns the checked addresses host resolves to. Hosts on the
+// allow list are trusted as they are and return nil, leaving resolution to
+// the caller.
+func (p *Policy) addresses(ctx context.Context, host string) ([]netip.Addr, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return nil, fmt.Errorf("%w: missing host", ErrBlocked)
+	}
+	if matchHost(host, p.denyHosts) {
+		return nil, fmt.Errorf("%w: host %s is denied", ErrBlocked, host)
+	}
+	if matchHost(host, p.allowHosts) {
+		return nil, nil
+	}
+
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else if numericHost(host) {
+		return nil, fmt.Errorf("%w: host %s is not a valid name", ErrBlocked, host)
+	} else {
+		if p.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, p.timeout)
+			defer cancel()
+		}
+		if addrs, err = p.resolver.LookupNetIP(ctx, "ip", host); err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", host, err)
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("resolve %s: no addresses", host)
+		}
+	}
+	// A name with any internal address is rejected outright, otherwise an
+	// attacker could mix addresses and rely on the fetcher's choice.
+	for _, addr := range addrs {
+		if err := p.checkAddr(addr); err != nil {
+			return nil, fmt.Errorf("%s: %w", host, err)
+		}
+	}
+	return addrs, nil
+}
+
+func (p *Policy) checkPort(port int) error {
+	if !p.ports[port] {
+		return fmt.Errorf("%w: port %d", ErrBlocked, port)
+	}
+	return nil
+}
+
+// Check validates rawURL: an http or https URL on an allowed port whose host
+// resolves only to public addresses. Resolution errors are returned as they
+// are and do not wrap ErrBlocked.
+func (p *Policy) Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: must be an http or https URL", ErrBlocked)
+	}
+	port := 80
+	if u.Scheme == "https" {
+		port = 443
+	}
+	if u.Port() != "" {
+		if port, err = strconv.Atoi(u.Port()); err != nil {
+			return fmt.Errorf("%w: invalid port", ErrBlocked)
+		}
+	}
+	if err = p.checkPort(port); err != nil {
+		return err
+	}
+	_, err = p.addresses(ctx, u.Hostname())
+	return err
+}
+
+// dialContext connects only to addresses that passed the policy. It dials
+// the checked address itself rather than the name, so a DNS answer that
+// changes between the check and the connection cannot point it elsewhere.
+func (p *Policy) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, portValue, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.checkPort(port); err != nil {
+		return nil, err
+	}
+	addrs, err := p.addresses(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	if addrs == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, netip.AddrPortFrom(addr, uint16(port)).String())
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Client returns an HTTP client that enforces the policy on every
+// connection and redirect. Environment proxies are ignored since the proxy,
+// not the client, would pick the destination.
+func (p *Policy) Client(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = p.dialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return p.Check(req.Context(), req.URL.String())
+		},
+	}
+}
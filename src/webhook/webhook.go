@@ -0,0 +1,153 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"speechToText/src/config"
+	"speechToText/src/db"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"speechToText/src/urlpolicy"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	baseBackoff = 10 * time.Second
+	maxBackoff  = time.Hour
+)
+
+// Sign returns the signature header value for a payload: "sha256=" followed
+// by the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns the delay after the given number of failed attempts:
+// 10s, 20s, 40s, ... capped at an hour.
+func Backoff(attempts int) time.Duration {
+	if attempts > 16 {
+		return maxBackoff
+	}
+	d := baseBackoff << attempts
+	if d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+// Dispatcher delivers queued webhook events. Deliveries go through the
+// callback URL policy, so endpoints that resolve to internal addresses
+// after registration are not reached either.
+type Dispatcher struct {
+	store       *db.Store
+	client      *http.Client
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+}
+
+func NewDispatcher(store *db.Store, cfg *config.WebhookConfig) *Dispatcher {
+	return &Dispatcher{
+		store:       store,
+		client:      urlpolicy.New(config.CurrentConfig.CallbackURL).Client(cfg.Timeout),
+		interval:    cfg.PollInterval,
+		batchSize:   cfg.BatchSize,
+		maxAttempts: cfg.MaxAttempts,
+	}
+}
+
+// Run polls for due deliveries until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.flush(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) flush(ctx context.Context) {
+	// The lease must outlast one attempt, otherwise another dispatcher could
+	// pick the delivery up while it is still being sent.
+	deliveries, err := d.store.ClaimWebhookDeliveries(d.batchSize, 2*d.client.Timeout+time.Minute)
+	if err != nil {
+		service.LogError("webhook claim: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.attempt(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery types.PendingDelivery) {
+	code, err := d.send(ctx, delivery)
+	if err == nil {
+		if err := d.store.MarkWebhookDelivered(delivery.ID, code); err != nil {
+			service.LogError("webhook delivery %d: %v", delivery.ID, err)
+		}
+		return
+	}
+
+	var retryIn time.Duration
+	if delivery.Attempts+1 < d.maxAttempts {
+		retryIn = Backoff(delivery.Attempts)
+	}
+	service.LogError("webhook delivery %d to %s (attempt %d): %v", delivery.ID, delivery.URL, delivery.Attempts+1, err)
+	if err := d.store.MarkWebhookAttemptFailed(delivery.ID, code, err.Error(), retryIn); err != nil {
+		service.LogError("webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery types.PendingDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
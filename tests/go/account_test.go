@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"speechToText/src/cache"
+	"speechToText/src/config"
+	"speechToText/src/service"
+	"speechToText/src/types"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestAccountHandlers(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		handler        http.HandlerFunc
+		expectedStatus int
+	}{
+		{name: "Profile without session", method: "GET", target: "/me", handler: testHandlers.Me, expectedStatus: 401},
+		{name: "Password without session", method: "POST", target: "/me/password", handler: testHandlers.ChangePassword, expectedStatus: 401},
+		{name: "Delete without session", method: "DELETE", target: "/me", handler: testHandlers.DeleteAccount, expectedStatus: 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		expected string
+	}{
+		{username: "alice", expected: "alice"},
+		{username: "Alice", expected: "alice"},
+		{username: "  BOB ", expected: "bob"},
+		{username: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			if got := service.NormalizeUsername(tt.username); got != tt.expected {
+				t.Errorf("NormalizeUsername(%q) = %q, want %q", tt.username, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAccountStore(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	if _, err := testStore.GetProfile("no_such_user"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for the profile of an unknown user, got %v", err)
+	}
+	if _, err := testStore.ChangePassword("no_such_user", "hash", ""); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows changing the password of an unknown user, got %v", err)
+	}
+	if _, _, err := testStore.DeleteAccount("no_such_user"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows deleting an unknown user, got %v", err)
+	}
+}
+
+func TestDeleteAccountUnfinishedTasks(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	username := "delete_" + uuid.New().String()[:8]
+	if err := testStore.AddAuthData(username, "hash"); err != nil {
+		t.Fatalf("AddAuthData: %v", err)
+	}
+	newTask := func() string {
+		taskID := uuid.New().String()
+		if err := testStore.AddAudioTask(taskID, username, types.AudioRequest{Audio: "https://example.com/a.wav"}, "test_queue"); err != nil {
+			t.Fatalf("AddAudioTask: %v", err)
+		}
+		return taskID
+	}
+	queued, running, completed := newTask(), newTask(), newTask()
+	for _, taskID := range []string{running, completed} {
+		if _, err := testStore.StartTask(taskID); err != nil {
+			t.Fatalf("StartTask: %v", err)
+		}
+	}
+	if err := testStore.AddResultTask(completed, types.Transcript{Text: "hello"}); err != nil {
+		t.Fatalf("AddResultTask: %v", err)
+	}
+
+	_, unfinished, err := testStore.DeleteAccount(username)
+	if err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	slices.Sort(unfinished)
+	want := []string{queued, running}
+	slices.Sort(want)
+	if !slices.Equal(unfinished, want) {
+		t.Errorf("unfinished tasks = %v, want %v", unfinished, want)
+	}
+}
+
+func TestDeleteUserData(t *testing.T) {
+	provider := cache.NewRedisSessionProvider(config.CurrentConfig.Redis.Host)
+	defer provider.Close()
+	ctx := context.Background()
+	if err := provider.Client.Ping(ctx).Err(); err != nil {
+		t.Skip("Redis not available")
+	}
+	username := "delete_" + uuid.New().String()[:8]
+	gone := []string{
+		"tasks:events:" + username,
+		"idempotency:" + username + ":k1",
+		"idempotency:" + username + ":k2",
+		"login:fail:user:" + username,
+		"login:wait:user:" + username,
+	}
+	kept := []string{"idempotency:" + username + "x:k1", "login:fail:ip:192.0.2.12"}
+	for _, key := range append(slices.Clone(gone), kept...) {
+		if err := provider.Client.Set(ctx, key, "1", time.Minute).Err(); err != nil {
+			t.Fatalf("Set %s: %v", key, err)
+		}
+	}
+	defer provider.Client.Del(ctx, kept...)
+
+	if err := cache.DeleteUserData(ctx, provider.Client, username); err != nil {
+		t.Fatalf("DeleteUserData: %v", err)
+	}
+	if n, _ := provider.Client.Exists(ctx, gone...).Result(); n != 0 {
+		t.Errorf("%d keys of the deleted user are left", n)
+	}
+	if n, _ := provider.Client.Exists(ctx, kept...).Result(); n != int64(len(kept)) {
+		t.Errorf("deleted keys of other users: %d of %d left", n, len(kept))
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"speechToText/src/types"
+	"testing"
+	"time"
+)
+
+func TestAdminHandlers(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		handler        http.HandlerFunc
+		expectedStatus int
+	}{
+		{name: "Users without session", method: "GET", target: "/admin/users", handler: testHandlers.AdminUsers, expectedStatus: 401},
+		{name: "Disable without session", method: "POST", target: "/admin/users/someone/disable", handler: testHandlers.DisableUser, expectedStatus: 401},
+		{name: "Enable without session", method: "POST", target: "/admin/users/someone/enable", handler: testHandlers.EnableUser, expectedStatus: 401},
+		{name: "Role without session", method: "PUT", target: "/admin/users/someone/role", handler: testHandlers.SetUserRole, expectedStatus: 401},
+		{name: "Task without session", method: "GET", target: "/admin/tasks/some-id", handler: testHandlers.AdminTask, expectedStatus: 401},
+		{name: "Requeue without session", method: "POST", target: "/admin/tasks/some-id/requeue", handler: testHandlers.AdminRequeueTask, expectedStatus: 401},
+		{name: "Stats without session", method: "GET", target: "/admin/stats", handler: testHandlers.AdminStats, expectedStatus: 401},
+		{name: "Audit log without session", method: "GET", target: "/admin/audit", handler: testHandlers.AuditLog, expectedStatus: 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestAdminStore(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	if _, err := testStore.GetUserAccess("no_such_user"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for an unknown user, got %v", err)
+	}
+	if found, err := testStore.SetUserDisabled("admin", "no_such_user", true); err != nil || found {
+		t.Errorf("Disabling an unknown user should report not found, got %v, %v", found, err)
+	}
+	if found, err := testStore.SetUserRole("admin", "no_such_user", types.RoleSupport); err != nil || found {
+		t.Errorf("Changing the role of an unknown user should report not found, got %v, %v", found, err)
+	}
+	if requeued, err := testStore.RequeueTask("admin", "no_such_task", time.Minute, "test_queue"); err != nil || requeued {
+		t.Errorf("Requeueing an unknown task should report not requeued, got %v, %v", requeued, err)
+	}
+	entries, err := testStore.ListAuditLog("admin", "admin", 10, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Actor != "admin" {
+			t.Errorf("Expected only entries of admin, got %q", entry.Actor)
+		}
+	}
+}
+
+func TestRequeueActiveTask(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	tests := []struct {
+		name         string
+		staleAfter   time.Duration
+		wantRequeued bool
+		wantStatus   types.TaskStatus
+	}{
+		{name: "Task with recent heartbeat is left to its worker", staleAfter: time.Hour, wantStatus: types.StatusDownloading},
+		{name: "Task without heartbeat is requeued", wantRequeued: true, wantStatus: types.StatusQueued},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskID := newReaperTask(t, true)
+			if err := testStore.HeartbeatTask(taskID); err != nil {
+				t.Fatalf("HeartbeatTask: %v", err)
+			}
+			requeued, err := testStore.RequeueTask("admin", taskID, tt.staleAfter, "test_queue")
+			if err != nil {
+				t.Fatalf("RequeueTask: %v", err)
+			}
+			if requeued != tt.wantRequeued {
+				t.Errorf("requeued = %v, want %v", requeued, tt.wantRequeued)
+			}
+			status, err := testStore.GetStatusTask(taskID)
+			if err != nil {
+				t.Fatalf("GetStatusTask: %v", err)
+			}
+			if status.Status != tt.wantStatus {
+				t.Errorf("task is %s, want %s", status.Status, tt.wantStatus)
+			}
+			published := drainOutbox(t, taskID, func(types.OutboxMessage) error { return nil })
+			if (published == 1) != tt.wantRequeued || published > 1 {
+				t.Errorf("relay published %d messages for the task, requeued = %v", published, tt.wantRequeued)
+			}
+		})
+	}
+}
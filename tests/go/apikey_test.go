@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"speechToText/src/auth"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, err := auth.NewAPIKey()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(key, auth.KeyPrefix) || !strings.HasPrefix(key, prefix) || len(prefix) >= len(key) {
+		t.Errorf("Key %q should start with %q and its prefix %q", key, auth.KeyPrefix, prefix)
+	}
+	other, _, err := auth.NewAPIKey()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if other == key {
+		t.Errorf("Keys should be random")
+	}
+	if auth.HashToken(key) != auth.HashToken(key) || auth.HashToken(key) == auth.HashToken(other) {
+		t.Errorf("HashToken should be deterministic and distinguish keys")
+	}
+}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		handler        http.HandlerFunc
+		expectedStatus int
+	}{
+		{name: "Create without session", method: "POST", target: "/keys", handler: testHandlers.CreateAPIKey, expectedStatus: 401},
+		{name: "List without session", method: "GET", target: "/keys", handler: testHandlers.APIKeys, expectedStatus: 401},
+		{name: "Revoke without session", method: "DELETE", target: "/keys/some-id", handler: testHandlers.RevokeAPIKey, expectedStatus: 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestUseAPIKey(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	if _, _, err := testStore.UseAPIKey(auth.HashToken("stt_unknown")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for an unknown key, got %v", err)
+	}
+
+	username := "apikey_" + uuid.New().String()[:8]
+	if err := testStore.AddAuthData(username, "hash"); err != nil {
+		t.Fatalf("AddAuthData: %v", err)
+	}
+	defer testStore.DeleteAccount(username)
+	hash := auth.HashToken("stt_" + uuid.New().String())
+	created, err := testStore.CreateAPIKey(username, "ci", "stt_test", hash, []string{"tasks:read"})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	first, _, err := testStore.UseAPIKey(hash)
+	if err != nil || first.ID != created.ID || first.LastUsedAt == "" {
+		t.Fatalf("first use = %+v, %v", first, err)
+	}
+	// A second use within the minute is served without writing last_used_at.
+	second, role, err := testStore.UseAPIKey(hash)
+	if err != nil || second.ID != created.ID || role == "" {
+		t.Fatalf("second use = %+v, %q, %v", second, role, err)
+	}
+	if second.LastUsedAt != first.LastUsedAt {
+		t.Errorf("last used at moved from %s to %s within a minute", first.LastUsedAt, second.LastUsedAt)
+	}
+
+	if _, err := testStore.RevokeAPIKey(created.ID, username); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, _, err := testStore.UseAPIKey(hash); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a revoked key, got %v", err)
+	}
+}
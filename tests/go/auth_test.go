@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"speechToText/src/auth"
+	"speechToText/src/types"
+	"testing"
+)
+
+func TestAuthRequirements(t *testing.T) {
+	session := &auth.Principal{Username: "testuser", Method: auth.MethodSession, SessionID: "sid"}
+	readKey := &auth.Principal{Username: "testuser", Method: auth.MethodAPIKey, Scopes: []string{types.ScopeTasksRead}, KeyID: "key"}
+	support := &auth.Principal{Username: "helper", Method: auth.MethodSession, Role: types.RoleSupport, SessionID: "sid"}
+	staff := auth.RequireRole(types.RoleAdmin, types.RoleSupport)
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		middleware     func(http.Handler) http.Handler
+		expectedStatus int
+	}{
+		{name: "Scope without principal", middleware: auth.RequireScope(types.ScopeTasksRead), expectedStatus: 401},
+		{name: "Scope with session", principal: session, middleware: auth.RequireScope(types.ScopeTasksDelete), expectedStatus: 200},
+		{name: "Scope granted to key", principal: readKey, middleware: auth.RequireScope(types.ScopeTasksRead), expectedStatus: 200},
+		{name: "Scope missing from key", principal: readKey, middleware: auth.RequireScope(types.ScopeTasksWrite), expectedStatus: 403},
+		{name: "Session route with session", principal: session, middleware: auth.RequireLogin, expectedStatus: 200},
+		{name: "Session route with key", principal: readKey, middleware: auth.RequireLogin, expectedStatus: 403},
+		{name: "Role without principal", middleware: staff, expectedStatus: 401},
+		{name: "Role missing from user", principal: session, middleware: staff, expectedStatus: 403},
+		{name: "Role granted to support", principal: support, middleware: staff, expectedStatus: 200},
+		{name: "Admin role missing from support", principal: support, middleware: auth.RequireRole(types.RoleAdmin), expectedStatus: 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := httptest.NewRequest("GET", "/tasks", nil)
+			if tt.principal != nil {
+				req = req.WithContext(auth.NewContext(req.Context(), *tt.principal))
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
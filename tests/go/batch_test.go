@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"speechToText/src/consumer"
+	"speechToText/src/types"
+	"strings"
+	"testing"
+)
+
+func TestMergeAudioRequest(t *testing.T) {
+	defaults := types.AudioRequest{
+		CallbackURL:          "https://example.com/hook",
+		Tags:                 []string{"meeting"},
+		TranscriptionOptions: types.TranscriptionOptions{Model: "nova-2", Language: "en"},
+	}
+	tests := []struct {
+		name     string
+		item     types.AudioRequest
+		expected types.AudioRequest
+	}{
+		{
+			name: "Empty item takes defaults",
+			item: types.AudioRequest{Audio: "https://example.com/a.wav"},
+			expected: types.AudioRequest{
+				Audio:                "https://example.com/a.wav",
+				CallbackURL:          "https://example.com/hook",
+				Tags:                 []string{"meeting"},
+				TranscriptionOptions: types.TranscriptionOptions{Model: "nova-2", Language: "en"},
+			},
+		},
+		{
+			name: "Item options override defaults",
+			item: types.AudioRequest{
+				Audio:                "https://example.com/b.wav",
+				Tags:                 []string{"call"},
+				TranscriptionOptions: types.TranscriptionOptions{Language: "de"},
+			},
+			expected: types.AudioRequest{
+				Audio:                "https://example.com/b.wav",
+				CallbackURL:          "https://example.com/hook",
+				Tags:                 []string{"call"},
+				TranscriptionOptions: types.TranscriptionOptions{Model: "nova-2", Language: "de"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := consumer.MergeAudioRequest(tt.item, defaults); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MergeAudioRequest returned %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAudioBatch(t *testing.T) {
+	public := types.AudioRequest{Audio: "https://8.8.8.8/a.wav"}
+	tooMany := make([]types.AudioRequest, 501)
+	for i := range tooMany {
+		tooMany[i] = public
+	}
+	tests := []struct {
+		name           string
+		request        types.BatchRequest
+		expectedStatus int
+		expectedError  string
+	}{
+		{name: "No items", request: types.BatchRequest{}, expectedStatus: 400, expectedError: "items are required"},
+		{name: "Too many items", request: types.BatchRequest{Items: tooMany}, expectedStatus: 400, expectedError: "at most 500 items"},
+		{
+			name:           "Blocked source",
+			request:        types.BatchRequest{Items: []types.AudioRequest{public, {Audio: "http://127.0.0.1/b.wav"}}},
+			expectedStatus: 400,
+			expectedError:  "item 1:",
+		},
+		{
+			name: "Invalid default applies to every item",
+			request: types.BatchRequest{
+				Items:    []types.AudioRequest{public, public},
+				Defaults: types.AudioRequest{TranscriptionOptions: types.TranscriptionOptions{Model: "nova 2"}},
+			},
+			expectedStatus: 400,
+			expectedError:  "item 0: invalid model",
+		},
+		{
+			name: "Item overrides invalid default",
+			request: types.BatchRequest{
+				Items:    []types.AudioRequest{{Audio: public.Audio, TranscriptionOptions: types.TranscriptionOptions{Model: "nova-2"}}},
+				Defaults: types.AudioRequest{TranscriptionOptions: types.TranscriptionOptions{Model: "nova 2"}},
+			},
+			expectedStatus: 200,
+		},
+		{
+			name: "Defaults merged",
+			request: types.BatchRequest{
+				Items:    []types.AudioRequest{public, {Audio: "https://8.8.4.4/b.wav", Tags: []string{"call"}}},
+				Defaults: types.AudioRequest{Tags: []string{"meeting"}, TranscriptionOptions: types.TranscriptionOptions{Language: "de"}},
+			},
+			expectedStatus: 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.expectedStatus == 200 && testStore == nil {
+				t.Skip("DB not available")
+			}
+			body, _ := json.Marshal(tt.request)
+			req := asUser(httptest.NewRequest("POST", "/audio/batch", bytes.NewBuffer(body)), "batch_test_user")
+			rr := httptest.NewRecorder()
+			testHandlers.AudioBatch(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v (%s)", status, tt.expectedStatus, rr.Body)
+			}
+			if tt.expectedError != "" && !strings.Contains(rr.Body.String(), tt.expectedError) {
+				t.Errorf("Expected error containing %q, got %q", tt.expectedError, rr.Body)
+			}
+			if tt.expectedStatus != 200 {
+				return
+			}
+			var response types.BatchResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if len(response.TaskIDs) != len(tt.request.Items) {
+				t.Fatalf("Expected %d tasks, got %d", len(tt.request.Items), len(response.TaskIDs))
+			}
+			tasks, _, err := testStore.ListTasks("batch_test_user", types.TaskQuery{
+				Filter: types.TaskFilter{BatchID: response.BatchID, Limit: 10},
+				Sort:   types.SortCreatedAt,
+			})
+			if err != nil {
+				t.Fatalf("ListTasks: %v", err)
+			}
+			for _, task := range tasks {
+				item := tt.request.Items[slices.Index(response.TaskIDs, task.TaskID)]
+				expected := consumer.MergeAudioRequest(item, tt.request.Defaults)
+				if !slices.Equal(task.Tags, expected.Tags) || task.Language != expected.Language {
+					t.Errorf("task %s stored tags %v and language %q, want %v and %q",
+						task.TaskID, task.Tags, task.Language, expected.Tags, expected.Language)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"speechToText/src/consumer"
+	"speechToText/src/types"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	const source = "https://example.com/a.wav"
+	header := func(pairs ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i], pairs[i+1])
+		}
+		return h
+	}
+	base := consumer.Fingerprint(source, header("ETag", `"v1"`, "Content-Length", "1024"), types.TranscriptionOptions{})
+
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		opts   types.TranscriptionOptions
+		same   bool
+	}{
+		{name: "Same source", url: source, header: header("ETag", `"v1"`, "Content-Length", "1024"), same: true},
+		{name: "Default options spelled out", url: source, header: header("ETag", `"v1"`, "Content-Length", "1024"),
+			opts: types.TranscriptionOptions{Model: "nova-2", Language: "en"}, same: true},
+		{name: "Changed ETag", url: source, header: header("ETag", `"v2"`, "Content-Length", "1024")},
+		{name: "Changed length", url: source, header: header("ETag", `"v1"`, "Content-Length", "2048")},
+		{name: "Other URL", url: "https://example.com/b.wav", header: header("ETag", `"v1"`, "Content-Length", "1024")},
+		{name: "Other language", url: source, header: header("ETag", `"v1"`, "Content-Length", "1024"),
+			opts: types.TranscriptionOptions{Language: "de"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := consumer.Fingerprint(tt.url, tt.header, tt.opts)
+			if (got == base) != tt.same {
+				t.Errorf("Fingerprint match = %v, want %v", got == base, tt.same)
+			}
+		})
+	}
+
+	if got := consumer.Fingerprint(source, header("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT"), types.TranscriptionOptions{}); got != "" {
+		t.Errorf("Fingerprint without ETag or Content-Length should be empty, got %s", got)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"speechToText/src/config"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		expectedError string
+	}{
+		{name: "Defaults"},
+		{name: "Zero reaper interval", env: map[string]string{"REAPER_INTERVAL": "0s"}, expectedError: "REAPER_INTERVAL"},
+		{name: "Negative heartbeat", env: map[string]string{"TASK_HEARTBEAT_INTERVAL": "-1s"}, expectedError: "TASK_HEARTBEAT_INTERVAL"},
+		{name: "Zero export poll", env: map[string]string{"EXPORT_POLL_INTERVAL": "0"}, expectedError: "EXPORT_POLL_INTERVAL"},
+		{name: "Unparsable interval keeps the default", env: map[string]string{"WEBHOOK_POLL_INTERVAL": "soon"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			err := config.NewConfig().Validate()
+			if tt.expectedError == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Validate() = %v, want an error about %s", err, tt.expectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"speechToText/src/consumer"
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 0, max: time.Second},
+		{attempt: 1, max: 2 * time.Second},
+		{attempt: 4, max: 16 * time.Second},
+		{attempt: 5, max: 30 * time.Second},
+		{attempt: 50, max: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			got := consumer.ReconnectBackoff(tt.attempt)
+			if got < tt.max/2 || got > tt.max {
+				t.Errorf("ReconnectBackoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
@@ -1,38 +1,55 @@
 package main
 
 import (
+	"speechToText/src/types"
 	"testing"
+	"time"
 )
 
-func TestGetTasksWithPagination(t *testing.T) {
+func TestListTasks(t *testing.T) {
 	if testStore == nil {
 		t.Skip("DB not available")
 	}
 	tests := []struct {
 		name      string
 		username  string
-		page      int
-		pageSize  int
+		query     types.TaskQuery
 		expectErr bool
 	}{
-		{name: "Valid pagination", username: "testuser", page: 1, pageSize: 10},
-		{name: "Invalid page (zero)", username: "testuser", page: 0, pageSize: 10},
-		{name: "Invalid page size (zero)", username: "testuser", page: 1, pageSize: 0},
-		{name: "Empty username", username: "", page: 1, pageSize: 10},
+		{name: "First page", username: "testuser", query: types.TaskQuery{Filter: types.TaskFilter{Limit: 10}, Sort: types.SortCreatedAt, Desc: true}},
+		{name: "Offset page", username: "testuser", query: types.TaskQuery{Filter: types.TaskFilter{Limit: 10}, Sort: types.SortCreatedAt, Offset: 10}},
+		{name: "Filtered by status and tag", username: "testuser", query: types.TaskQuery{
+			Filter: types.TaskFilter{Statuses: []types.TaskStatus{types.StatusCompleted}, Tag: "meeting", TextPrefix: "50%_", Limit: 10},
+			Sort:   types.SortStatus,
+		}},
+		{name: "After cursor", username: "testuser", query: types.TaskQuery{
+			Filter: types.TaskFilter{Limit: 10},
+			Sort:   types.SortLanguage,
+			After:  &types.TaskCursor{Key: "en", Created: time.Now(), TaskID: "task"},
+		}},
+		{name: "Unknown sort", username: "testuser", query: types.TaskQuery{Filter: types.TaskFilter{Limit: 10}, Sort: "audio"}, expectErr: true},
+		{name: "Empty username", username: "", query: types.TaskQuery{Filter: types.TaskFilter{Limit: 10}, Sort: types.SortCreatedAt}},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tasks, total, err := testStore.GetTasksWithPagination(tt.username, tt.page, tt.pageSize)
-			if tt.expectErr && err == nil {
-				t.Errorf("Expected error but got none")
+			tasks, _, err := testStore.ListTasks(tt.username, tt.query)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
 			}
-			if !tt.expectErr && err != nil {
+			if err != nil {
 				t.Errorf("Unexpected error: %v", err)
 			}
 			if tasks == nil {
 				t.Errorf("Tasks should not be nil")
 			}
+			total, err := testStore.CountTasks(tt.username, tt.query.Filter)
+			if err != nil {
+				t.Errorf("Unexpected count error: %v", err)
+			}
 			if total < 0 {
 				t.Errorf("Total should not be negative")
 			}
@@ -144,7 +161,7 @@ func TestValidateUser(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			valid, err := testStore.CheckAuthData(tt.username, tt.password)
+			_, valid, err := testStore.CheckAuthData(tt.username, tt.password)
 			if tt.expectErr && err == nil {
 				t.Errorf("Expected error but got none")
 			}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"speechToText/src/consumer"
+	"speechToText/src/types"
+	"testing"
+
+	interfaces "github.com/deepgram/deepgram-go-sdk/pkg/client/interfaces"
+)
+
+func deepgramError(status int, errCode string, errMsg string) error {
+	req, _ := http.NewRequest("POST", "https://api.deepgram.com/v1/listen", nil)
+	statusErr := &interfaces.StatusError{Resp: &http.Response{StatusCode: status, Status: http.StatusText(status), Request: req}}
+	if errCode != "" || errMsg != "" {
+		statusErr.DeepgramError = &interfaces.DeepgramError{ErrCode: errCode, ErrMsg: errMsg}
+	}
+	return statusErr
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "Invalid API key", err: deepgramError(401, "INVALID_AUTH", "Invalid credentials."), expected: types.ErrorProviderAuth},
+		{name: "Source not reachable", err: deepgramError(400, "REMOTE_CONTENT_ERROR", "could not fetch URL"), expected: types.ErrorSourceUnreachable},
+		{name: "Unsupported audio", err: deepgramError(400, "Bad Request", "corrupt or unsupported data"), expected: types.ErrorUnsupportedFormat},
+		{name: "Other bad request", err: deepgramError(400, "Bad Request", "invalid model"), expected: types.ErrorProviderRejected},
+		{name: "Provider outage", err: deepgramError(503, "", ""), expected: types.ErrorProviderError},
+		{name: "Rate limited", err: deepgramError(429, "", ""), expected: types.ErrorProviderError},
+		{name: "Gateway timeout", err: deepgramError(504, "", ""), expected: types.ErrorProviderTimeout},
+		{name: "Context deadline", err: fmt.Errorf("post: %w", context.DeadlineExceeded), expected: types.ErrorProviderTimeout},
+		{name: "Task error passes through", err: &consumer.TaskError{Code: types.ErrorEmptyResult, Message: "empty"}, expected: types.ErrorEmptyResult},
+		{name: "Unknown error", err: errors.New("boom"), expected: types.ErrorInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := consumer.ClassifyError(tt.err); got.Code != tt.expected {
+				t.Errorf("ClassifyError returned %s, want %s", got.Code, tt.expected)
+			}
+		})
+	}
+}
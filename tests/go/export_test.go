@@ -0,0 +1,173 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"speechToText/src/export"
+	"speechToText/src/types"
+	"strings"
+	"testing"
+)
+
+var exportRows = []types.ExportRow{
+	{
+		TaskID:   "task-1",
+		Language: "en",
+		Tags:     []string{"meeting"},
+		Duration: 3.5,
+		Result:   "Hello there. General Kenobi.",
+		Segments: []types.Segment{
+			{Start: 0, End: 1.25, Text: "Hello there."},
+			{Start: 1.5, End: 3.5, Text: "General Kenobi."},
+		},
+	},
+	{TaskID: "task-2", Result: "No timings", Duration: 2},
+}
+
+func eachExportRow(fn func(types.ExportRow) error) error {
+	for _, row := range exportRows {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestExportSubtitles(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    func(*bytes.Buffer, types.ExportRow) error
+		row      types.ExportRow
+		expected string
+	}{
+		{
+			name:     "SRT",
+			write:    func(b *bytes.Buffer, row types.ExportRow) error { return export.WriteSRT(b, row) },
+			row:      exportRows[0],
+			expected: "1\n00:00:00,000 --> 00:00:01,250\nHello there.\n\n2\n00:00:01,500 --> 00:00:03,500\nGeneral Kenobi.\n\n",
+		},
+		{
+			name:     "VTT",
+			write:    func(b *bytes.Buffer, row types.ExportRow) error { return export.WriteVTT(b, row) },
+			row:      exportRows[0],
+			expected: "WEBVTT\n\n00:00:00.000 --> 00:00:01.250\nHello there.\n\n00:00:01.500 --> 00:00:03.500\nGeneral Kenobi.\n\n",
+		},
+		{
+			name:     "SRT without segments",
+			write:    func(b *bytes.Buffer, row types.ExportRow) error { return export.WriteSRT(b, row) },
+			row:      exportRows[1],
+			expected: "1\n00:00:00,000 --> 00:00:02,000\nNo timings\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := tt.write(&b, tt.row); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if b.String() != tt.expected {
+				t.Errorf("Got %q, want %q", b.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestExportWrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		files     []string
+		check     func(t *testing.T, data []byte)
+		expectErr bool
+	}{
+		{
+			name:   "JSONL",
+			format: types.ExportJSONL,
+			check: func(t *testing.T, data []byte) {
+				lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+				if len(lines) != 2 || !strings.Contains(lines[0], `"task_id":"task-1"`) {
+					t.Errorf("Unexpected JSONL output: %s", data)
+				}
+			},
+		},
+		{
+			name:   "CSV",
+			format: types.ExportCSV,
+			check: func(t *testing.T, data []byte) {
+				lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+				if len(lines) != 3 || !strings.HasPrefix(lines[0], "task_id,") || !strings.HasPrefix(lines[1], "task-1,en,meeting,") {
+					t.Errorf("Unexpected CSV output: %s", data)
+				}
+			},
+		},
+		{
+			name:   "ZIP",
+			format: types.ExportZIP,
+			files:  []string{types.ExportFileTXT, types.ExportFileSRT},
+			check: func(t *testing.T, data []byte) {
+				archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+				if err != nil {
+					t.Fatalf("Invalid archive: %v", err)
+				}
+				var names []string
+				for _, f := range archive.File {
+					names = append(names, f.Name)
+				}
+				if strings.Join(names, " ") != "task-1.txt task-1.srt task-2.txt task-2.srt" {
+					t.Errorf("Unexpected archive entries: %v", names)
+				}
+			},
+		},
+		{name: "Unknown format", format: "xml", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			count, err := export.Write(&b, tt.format, tt.files, eachExportRow)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if count != len(exportRows) {
+				t.Errorf("Wrote %d rows, want %d", count, len(exportRows))
+			}
+			tt.check(t, b.Bytes())
+		})
+	}
+}
+
+func TestExportHandlers(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		handler        http.HandlerFunc
+		expectedStatus int
+	}{
+		{name: "Create without session", method: "POST", target: "/exports", handler: testHandlers.CreateExport, expectedStatus: 401},
+		{name: "Status without session", method: "GET", target: "/exports/some-id", handler: testHandlers.Export, expectedStatus: 401},
+		{name: "Download without signature", method: "GET", target: "/exports/some-id/download", handler: testHandlers.DownloadExport, expectedStatus: 403},
+		{name: "Download with bad signature", method: "GET", target: "/exports/some-id/download?expires=9999999999&signature=00", handler: testHandlers.DownloadExport, expectedStatus: 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"speechToText/src/api"
+	"speechToText/src/cache"
+	"speechToText/src/config"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	provider := cache.NewRedisSessionProvider(config.CurrentConfig.Redis.Host)
+	defer provider.Close()
+	middleware := api.NewIdempotencyMiddleware(cache.NewIdempotencyStore(provider.Client, time.Hour, time.Minute))
+
+	tests := []struct {
+		name           string
+		key            string
+		expectedStatus int
+		expectNext     bool
+	}{
+		{name: "No key passes through", expectedStatus: 200, expectNext: true},
+		{name: "Key too long", key: strings.Repeat("k", 256), expectedStatus: 400},
+		{name: "Key without principal", key: "retry-1", expectedStatus: 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			req := httptest.NewRequest("POST", "/audio", strings.NewReader(`{"audio":"https://example.com/a.wav"}`))
+			if tt.key != "" {
+				req.Header.Set(api.IdempotencyHeader, tt.key)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if called != tt.expectNext {
+				t.Errorf("next handler called = %v, want %v", called, tt.expectNext)
+			}
+		})
+	}
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	provider := cache.NewRedisSessionProvider(config.CurrentConfig.Redis.Host)
+	defer provider.Close()
+	if err := provider.Client.Ping(context.Background()).Err(); err != nil {
+		t.Skip("Redis not available")
+	}
+	const lockTTL = 300 * time.Millisecond
+	middleware := api.NewIdempotencyMiddleware(cache.NewIdempotencyStore(provider.Client, time.Hour, lockTTL))
+
+	calls := 0
+	crash := false
+	disconnect := func() {}
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if crash {
+			panic("killed mid-request")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"task_id":"` + strconv.Itoa(calls) + `"}`))
+		disconnect()
+	}))
+	send := func(key string, body string, gone bool) (rr *httptest.ResponseRecorder) {
+		rr = httptest.NewRecorder()
+		defer func() {
+			if recover() != nil {
+				rr.Code = http.StatusInternalServerError
+			}
+		}()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		disconnect = func() {}
+		if gone {
+			disconnect = cancel
+		}
+		req := asUser(httptest.NewRequestWithContext(ctx, "POST", "/audio", strings.NewReader(body)), "idempotency_test_user")
+		req.Header.Set(api.IdempotencyHeader, key)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	first := `{"audio":"https://example.com/a.wav"}`
+	second := `{"audio":"https://example.com/b.wav"}`
+	key := uuid.New().String()
+
+	tests := []struct {
+		name           string
+		key            string
+		body           string
+		crash          bool
+		disconnect     bool
+		wait           time.Duration
+		expectedStatus int
+		expectedBody   string
+		expectedCalls  int
+	}{
+		{name: "First request runs", key: key, body: first, expectedStatus: 200, expectedBody: `{"task_id":"1"}`, expectedCalls: 1},
+		{name: "Same body is replayed", key: key, body: first, expectedStatus: 200, expectedBody: `{"task_id":"1"}`, expectedCalls: 1},
+		{name: "Different body is rejected", key: key, body: second, expectedStatus: 422, expectedCalls: 1},
+		{name: "Request dies holding the key", key: key + "-crash", body: first, crash: true, expectedStatus: 500, expectedCalls: 2},
+		{name: "Key is locked meanwhile", key: key + "-crash", body: first, expectedStatus: 409, expectedCalls: 2},
+		{name: "Lock expires", key: key + "-crash", body: first, wait: 2 * lockTTL, expectedStatus: 200, expectedBody: `{"task_id":"3"}`, expectedCalls: 3},
+		{name: "Client disconnects once the task is created", key: key + "-gone", body: first, disconnect: true,
+			expectedStatus: 200, expectedBody: `{"task_id":"4"}`, expectedCalls: 4},
+		{name: "Retry after the lock TTL is replayed", key: key + "-gone", body: first, wait: 2 * lockTTL,
+			expectedStatus: 200, expectedBody: `{"task_id":"4"}`, expectedCalls: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			time.Sleep(tt.wait)
+			crash = tt.crash
+			rr := send(tt.key, tt.body, tt.disconnect)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if tt.expectedBody != "" && rr.Body.String() != tt.expectedBody {
+				t.Errorf("body = %s, want %s", rr.Body, tt.expectedBody)
+			}
+			if calls != tt.expectedCalls {
+				t.Errorf("handler ran %d times, want %d", calls, tt.expectedCalls)
+			}
+		})
+	}
+}
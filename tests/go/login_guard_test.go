@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"speechToText/src/api"
+	"speechToText/src/auth"
+	"speechToText/src/cache"
+	"speechToText/src/config"
+	appmetrics "speechToText/src/metrics"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLoginDelay(t *testing.T) {
+	cfg := &config.LoginConfig{BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	tests := []struct {
+		failures int64
+		expected time.Duration
+	}{
+		{failures: 0, expected: 0},
+		{failures: 1, expected: time.Second},
+		{failures: 2, expected: 2 * time.Second},
+		{failures: 4, expected: 8 * time.Second},
+		{failures: 6, expected: 30 * time.Second},
+		{failures: 100, expected: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := cache.LoginDelay(cfg, tt.failures); got != tt.expected {
+			t.Errorf("LoginDelay(%d) = %v, want %v", tt.failures, got, tt.expected)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{name: "Direct client", remoteAddr: "203.0.113.7:5000", expected: "203.0.113.7"},
+		{name: "Forwarded header from untrusted peer", remoteAddr: "203.0.113.7:5000", forwarded: "198.51.100.1", expected: "203.0.113.7"},
+		{name: "Trusted proxy", remoteAddr: "10.0.0.2:5000", forwarded: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "Spoofed entries before the real client", remoteAddr: "10.0.0.2:5000", forwarded: "1.2.3.4, 198.51.100.1, 10.0.0.3", expected: "198.51.100.1"},
+		{name: "Trusted proxy without header", remoteAddr: "10.0.0.2:5000", expected: "10.0.0.2"},
+		{name: "IPv6 client", remoteAddr: "[2001:db8::1]:5000", expected: "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/login", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := auth.ClientIP(req, proxies); got != tt.expected {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoginGuard(t *testing.T) {
+	provider := cache.NewRedisSessionProvider(config.CurrentConfig.Redis.Host)
+	defer provider.Close()
+	cfg := &config.LoginConfig{MaxFailures: 2, MaxIPFailures: 100, Window: time.Minute, Lockout: time.Minute}
+	throttle := cache.NewLoginThrottle(provider.Client, cfg)
+	guard := api.NewLoginGuard(throttle, testStore, &appmetrics.LoginMetrics{})
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectNext     bool
+	}{
+		{name: "Invalid JSON passes through", body: "{", expectedStatus: 200, expectNext: true},
+		{name: "Missing username passes through", body: `{"password":"secret123"}`, expectedStatus: 200, expectNext: true},
+		{name: "Oversized body", body: `{"username":"` + strings.Repeat("a", 128<<10) + `"}`, expectedStatus: 413},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			req := httptest.NewRequest("POST", "/login", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if called != tt.expectNext {
+				t.Errorf("next handler called = %v, want %v", called, tt.expectNext)
+			}
+		})
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	provider := cache.NewRedisSessionProvider(config.CurrentConfig.Redis.Host)
+	defer provider.Close()
+	ctx := context.Background()
+	if err := provider.Client.Ping(ctx).Err(); err != nil {
+		t.Skip("Redis not available")
+	}
+	cfg := &config.LoginConfig{MaxFailures: 2, MaxIPFailures: 100, Window: time.Minute, Lockout: time.Minute}
+	throttle := cache.NewLoginThrottle(provider.Client, cfg)
+	username, ip := "throttle_test_user", "192.0.2.10"
+	t.Cleanup(func() {
+		provider.Client.Del(ctx, "login:fail:user:"+username, "login:lock:user:"+username, "login:wait:user:"+username,
+			"login:inflight:user:"+username, "login:fail:ip:"+ip, "login:lock:ip:"+ip)
+	})
+
+	if wait, err := throttle.Wait(ctx, username, ip); err != nil || wait != 0 {
+		t.Fatalf("Fresh username should not wait, got %v, %v", wait, err)
+	}
+	if wait, err := throttle.Reserve(ctx, username, ip); err != nil || wait != 0 {
+		t.Fatalf("First attempt should be reserved, got %v, %v", wait, err)
+	}
+	if wait, err := throttle.Reserve(ctx, username, ip); err != nil || wait <= 0 {
+		t.Errorf("Attempt while another is in progress should wait, got %v, %v", wait, err)
+	}
+	if err := throttle.Release(ctx, username); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if wait, err := throttle.Reserve(ctx, username, ip); err != nil || wait != 0 {
+		t.Errorf("Attempt after release should be reserved, got %v, %v", wait, err)
+	}
+	if err := throttle.Release(ctx, username); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if failure, err := throttle.Fail(ctx, username, ip); err != nil || failure.UserLocked {
+		t.Fatalf("First failure should not lock out, got %+v, %v", failure, err)
+	}
+	failure, err := throttle.Fail(ctx, username, ip)
+	if err != nil || !failure.UserLocked || failure.IPLocked {
+		t.Fatalf("Second failure should lock out the username only, got %+v, %v", failure, err)
+	}
+	if wait, err := throttle.Wait(ctx, username, ip); err != nil || wait <= 0 {
+		t.Errorf("Locked out username should wait, got %v, %v", wait, err)
+	}
+}
+
+func TestPasswordGuard(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	provider := cache.NewRedisSessionProvider(config.CurrentConfig.Redis.Host)
+	defer provider.Close()
+	ctx := context.Background()
+	if err := provider.Client.Ping(ctx).Err(); err != nil {
+		t.Skip("Redis not available")
+	}
+	cfg := &config.LoginConfig{MaxFailures: 2, MaxIPFailures: 100, Window: time.Minute, Lockout: time.Minute}
+	guard := api.NewPasswordGuard(cache.NewLoginThrottle(provider.Client, cfg), testStore, &appmetrics.LoginMetrics{
+		Failures:  prometheus.NewCounter(prometheus.CounterOpts{Name: "test_failures"}),
+		Lockouts:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_lockouts"}, []string{"scope"}),
+		Throttled: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_throttled"}),
+	})
+	username, ip := "password_guard_user", "192.0.2.11"
+	clear := func() {
+		provider.Client.Del(ctx, "login:fail:user:"+username, "login:lock:user:"+username, "login:wait:user:"+username,
+			"login:inflight:user:"+username, "login:fail:ip:"+ip, "login:lock:ip:"+ip)
+	}
+	t.Cleanup(clear)
+
+	tests := []struct {
+		name       string
+		disconnect bool
+	}{
+		{name: "Client waits for the answer"},
+		// The server cancels the request context when the client half-closes
+		// the connection, but the client can still read the answer.
+		{name: "Client disconnects during the check", disconnect: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clear()
+			calls := 0
+			for i, want := range []int{403, 403, 429} {
+				reqCtx, cancel := context.WithCancel(ctx)
+				handler := guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					calls++
+					if tt.disconnect {
+						cancel()
+					}
+					http.Error(w, "current password is wrong", http.StatusForbidden)
+				}))
+				req := asUser(httptest.NewRequestWithContext(reqCtx, "POST", "/me/password", strings.NewReader(`{}`)), username)
+				req.RemoteAddr = ip + ":5000"
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				cancel()
+				if rr.Code != want {
+					t.Errorf("attempt %d returned %d, want %d", i+1, rr.Code, want)
+				}
+			}
+			if calls != 2 {
+				t.Errorf("password was checked %d times, want 2 before the lockout", calls)
+			}
+			if n, _ := provider.Client.Exists(ctx, "login:inflight:user:"+username).Result(); n != 0 {
+				t.Errorf("attempt reservation was not released")
+			}
+		})
+	}
+}
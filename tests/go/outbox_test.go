@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"speechToText/src/types"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// drainOutbox runs the relay step until no due messages are left and
+// returns how many messages of the task were handed to publish.
+func drainOutbox(t *testing.T, taskID string, publish func(types.OutboxMessage) error) int {
+	t.Helper()
+	published := 0
+	for {
+		sent, err := testStore.ProcessOutbox(100, func(msg types.OutboxMessage) error {
+			if msg.TaskID == taskID {
+				published++
+			}
+			return publish(msg)
+		})
+		if err != nil {
+			t.Fatalf("ProcessOutbox: %v", err)
+		}
+		if sent < 100 {
+			return published
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	ok := func(types.OutboxMessage) error { return nil }
+
+	taskID := uuid.New().String()
+	if err := testStore.AddAudioTask(taskID, "outbox_test_user", types.AudioRequest{Audio: "https://example.com/a.wav"}, "test_queue"); err != nil {
+		t.Fatalf("AddAudioTask: %v", err)
+	}
+	defer testStore.DeleteTask(taskID, "outbox_test_user")
+
+	if published := drainOutbox(t, taskID, ok); published != 1 {
+		t.Errorf("Expected the new task to leave exactly one unsent message, relay published %d", published)
+	}
+	if published := drainOutbox(t, taskID, ok); published != 0 {
+		t.Errorf("Expected the message to be marked sent, relay published it %d more times", published)
+	}
+
+	failing := uuid.New().String()
+	if err := testStore.AddAudioTask(failing, "outbox_test_user", types.AudioRequest{Audio: "https://example.com/b.wav"}, "test_queue"); err != nil {
+		t.Fatalf("AddAudioTask: %v", err)
+	}
+	defer testStore.DeleteTask(failing, "outbox_test_user")
+
+	brokerDown := func(msg types.OutboxMessage) error {
+		if msg.TaskID == failing {
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}
+	if published := drainOutbox(t, failing, brokerDown); published != 1 {
+		t.Errorf("Expected one publish attempt, got %d", published)
+	}
+	if published := drainOutbox(t, failing, ok); published != 0 {
+		t.Errorf("Expected the failed message to wait for its backoff, relay published it %d times", published)
+	}
+}
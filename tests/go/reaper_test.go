@@ -0,0 +1,81 @@
+package main
+
+import (
+	"slices"
+	"speechToText/src/types"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newReaperTask creates a task whose queue message has been published and,
+// if start is set, lets a worker pick it up.
+func newReaperTask(t *testing.T, start bool) string {
+	t.Helper()
+	taskID := uuid.New().String()
+	if err := testStore.AddAudioTask(taskID, "reaper_test_user", types.AudioRequest{Audio: "https://example.com/a.wav"}, "test_queue"); err != nil {
+		t.Fatalf("AddAudioTask: %v", err)
+	}
+	t.Cleanup(func() { testStore.DeleteTask(taskID, "reaper_test_user") })
+	drainOutbox(t, taskID, func(types.OutboxMessage) error { return nil })
+	if start {
+		if started, err := testStore.StartTask(taskID); err != nil || !started {
+			t.Fatalf("StartTask: %v, %v", started, err)
+		}
+	}
+	return taskID
+}
+
+func TestReapStuckTasks(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	tests := []struct {
+		name          string
+		start         bool
+		timeout       time.Duration
+		maxRequeues   int
+		wantRequeued  bool
+		wantFailed    bool
+		wantStatus    types.TaskStatus
+		wantErrorCode string
+	}{
+		{name: "Stale active task is requeued", start: true, maxRequeues: 3, wantRequeued: true, wantStatus: types.StatusQueued},
+		{name: "Active task with recent heartbeat is left alone", start: true, timeout: time.Hour, maxRequeues: 3, wantStatus: types.StatusDownloading},
+		{name: "Queued task is not a worker timeout", maxRequeues: 0, wantStatus: types.StatusQueued},
+		{name: "Requeue limit fails the task", start: true, maxRequeues: 0, wantFailed: true,
+			wantStatus: types.StatusFailed, wantErrorCode: types.ErrorWorkerTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskID := newReaperTask(t, tt.start)
+			if tt.start {
+				if err := testStore.HeartbeatTask(taskID); err != nil {
+					t.Fatalf("HeartbeatTask: %v", err)
+				}
+			}
+			result, err := testStore.ReapStuckTasks(tt.timeout, tt.maxRequeues, time.Hour, "test_queue")
+			if err != nil {
+				t.Fatalf("ReapStuckTasks: %v", err)
+			}
+			if got := slices.Contains(result.Requeued, taskID); got != tt.wantRequeued {
+				t.Errorf("requeued = %v, want %v", got, tt.wantRequeued)
+			}
+			if got := slices.Contains(result.Failed, taskID); got != tt.wantFailed {
+				t.Errorf("failed = %v, want %v", got, tt.wantFailed)
+			}
+			if slices.Contains(result.Republished, taskID) {
+				t.Errorf("message of a task queued just now should not be republished")
+			}
+			status, err := testStore.GetStatusTask(taskID)
+			if err != nil {
+				t.Fatalf("GetStatusTask: %v", err)
+			}
+			if status.Status != tt.wantStatus || status.ErrorCode != tt.wantErrorCode {
+				t.Errorf("task is %s (%q), want %s (%q)", status.Status, status.ErrorCode, tt.wantStatus, tt.wantErrorCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"speechToText/src/types"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestUpdateRetentionValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "Negative audio days", body: `{"audio_days":-1}`},
+		{name: "Too many transcript days", body: `{"transcript_days":4000}`},
+		{name: "Malformed body", body: `{"audio_days":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := asUser(httptest.NewRequest("PUT", "/retention", bytes.NewBufferString(tt.body)), "retention_test_user")
+			rr := httptest.NewRecorder()
+			testHandlers.UpdateRetention(rr, req)
+
+			if status := rr.Code; status != 400 {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, 400)
+			}
+		})
+	}
+}
+
+func TestUpdateRetentionRecomputesExpiry(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	username := "retention_" + uuid.New().String()[:8]
+	if err := testStore.AddAuthData(username, "hash"); err != nil {
+		t.Fatalf("AddAuthData: %v", err)
+	}
+	defer testStore.DeleteAccount(username)
+	taskID := uuid.New().String()
+	if err := testStore.AddAudioTask(taskID, username, types.AudioRequest{Audio: "https://example.com/a.wav"}, "test_queue"); err != nil {
+		t.Fatalf("AddAudioTask: %v", err)
+	}
+
+	// expiresIn returns how long after queueing the timestamp lies, or zero
+	// if it is unset.
+	expiresIn := func(t *testing.T, queuedAt string, expiresAt string) time.Duration {
+		t.Helper()
+		if expiresAt == "" {
+			return 0
+		}
+		queued, err := time.Parse(time.RFC3339, queuedAt)
+		if err != nil {
+			t.Fatalf("parse queued_at: %v", err)
+		}
+		expires, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			t.Fatalf("parse expiry: %v", err)
+		}
+		return expires.Sub(queued).Round(time.Hour)
+	}
+
+	tests := []struct {
+		name            string
+		body            string
+		wantAudio       time.Duration
+		wantTranscripts time.Duration
+	}{
+		{name: "Short retention", body: `{"audio_days":1,"transcript_days":7}`, wantAudio: 24 * time.Hour, wantTranscripts: 7 * 24 * time.Hour},
+		{name: "Longer retention", body: `{"audio_days":3,"transcript_days":30}`, wantAudio: 3 * 24 * time.Hour, wantTranscripts: 30 * 24 * time.Hour},
+		{name: "Keep forever", body: `{"audio_days":0,"transcript_days":0}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := asUser(httptest.NewRequest("PUT", "/retention", bytes.NewBufferString(tt.body)), username)
+			rr := httptest.NewRecorder()
+			testHandlers.UpdateRetention(rr, req)
+			if status := rr.Code; status != 200 {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, 200, rr.Body.String())
+			}
+
+			status, err := testStore.GetStatusTask(taskID)
+			if err != nil {
+				t.Fatalf("GetStatusTask: %v", err)
+			}
+			if got := expiresIn(t, status.QueuedAt, status.AudioExpiresAt); got != tt.wantAudio {
+				t.Errorf("audio expires %s after creation, want %s", got, tt.wantAudio)
+			}
+			if got := expiresIn(t, status.QueuedAt, status.ExpiresAt); got != tt.wantTranscripts {
+				t.Errorf("transcript expires %s after creation, want %s", got, tt.wantTranscripts)
+			}
+		})
+	}
+}
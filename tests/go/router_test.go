@@ -2,10 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http/httptest"
+	"speechToText/src/cache"
+	"speechToText/src/config"
 	"speechToText/src/types"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 func TestAudio(t *testing.T) {
@@ -132,3 +138,225 @@ func TestTasks(t *testing.T) {
 		})
 	}
 }
+
+func TestCancelTask(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	taskID := uuid.New().String()
+	if err := testStore.AddAudioTask(taskID, "cancel_test_user", types.AudioRequest{Audio: "https://example.com/a.wav"}, "test_queue"); err != nil {
+		t.Fatalf("AddAudioTask: %v", err)
+	}
+	defer testStore.DeleteTask(taskID, "cancel_test_user")
+
+	tests := []struct {
+		name           string
+		username       string
+		expectedStatus int
+		expectedTask   types.TaskStatus
+	}{
+		{name: "Task of another user", username: "someone_else", expectedStatus: 403, expectedTask: types.StatusQueued},
+		{name: "Queued task", username: "cancel_test_user", expectedStatus: 200, expectedTask: types.StatusCancelled},
+		{name: "Already cancelled", username: "cancel_test_user", expectedStatus: 409, expectedTask: types.StatusCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/tasks/"+taskID+"/cancel", nil)
+			req = withURLParams(asUser(req, tt.username), "id", taskID)
+			rr := httptest.NewRecorder()
+			testHandlers.CancelTask(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			status, err := testStore.GetStatusTask(taskID)
+			if err != nil {
+				t.Fatalf("GetStatusTask: %v", err)
+			}
+			if status.Status != tt.expectedTask {
+				t.Errorf("task is %s, want %s", status.Status, tt.expectedTask)
+			}
+			if tt.expectedTask == types.StatusCancelled && status.FinishedAt == "" {
+				t.Errorf("cancelled task should have finished_at set")
+			}
+		})
+	}
+}
+
+func TestParseStreamID(t *testing.T) {
+	tests := []struct {
+		id       string
+		ms, seq  uint64
+		expectOK bool
+	}{
+		{id: "1700000000000-0", ms: 1700000000000, expectOK: true},
+		{id: "1700000000000-12", ms: 1700000000000, seq: 12, expectOK: true},
+		{id: "1700000000000"},
+		{id: "abc-1"},
+		{id: "1-x"},
+		{id: "-"},
+		{id: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			ms, seq, ok := cache.ParseStreamID(tt.id)
+			if ok != tt.expectOK || (ok && (ms != tt.ms || seq != tt.seq)) {
+				t.Errorf("ParseStreamID(%q) = %d, %d, %v, want %d, %d, %v", tt.id, ms, seq, ok, tt.ms, tt.seq, tt.expectOK)
+			}
+		})
+	}
+}
+
+func TestStreamIDAfter(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{a: "1700000000001-0", b: "1700000000000-5", expected: true},
+		{a: "1700000000000-6", b: "1700000000000-5", expected: true},
+		{a: "1700000000000-5", b: "1700000000000-5"},
+		{a: "1700000000000-4", b: "1700000000000-5"},
+		// Numeric, not lexical, comparison.
+		{a: "1700000000000-10", b: "1700000000000-9", expected: true},
+		{a: "999-0", b: "1000-0"},
+	}
+
+	for _, tt := range tests {
+		if got := cache.StreamIDAfter(tt.a, tt.b); got != tt.expected {
+			t.Errorf("StreamIDAfter(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestTaskEventReplay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	provider := cache.NewRedisSessionProvider(config.CurrentConfig.Redis.Host)
+	defer provider.Close()
+	if err := provider.Client.Ping(ctx).Err(); err != nil {
+		t.Skip("Redis not available")
+	}
+	pubsub := cache.NewPubSub(provider.Client)
+	username := "events_" + uuid.New().String()[:8]
+
+	// Replay needs the stream IDs, which only subscribers see.
+	live, err := pubsub.TaskEvents(ctx, username, "")
+	if err != nil {
+		t.Fatalf("TaskEvents: %v", err)
+	}
+	var ids []string
+	for _, status := range []types.TaskStatus{types.StatusQueued, types.StatusDownloading, types.StatusCompleted} {
+		if err := pubsub.PublishTaskEvent(ctx, types.TaskEvent{TaskID: "task", Username: username, Status: status}); err != nil {
+			t.Fatalf("PublishTaskEvent: %v", err)
+		}
+		ids = append(ids, (<-live).ID)
+	}
+
+	replay, err := pubsub.TaskEvents(ctx, username, ids[0])
+	if err != nil {
+		t.Fatalf("TaskEvents: %v", err)
+	}
+	for i, want := range []types.TaskStatus{types.StatusDownloading, types.StatusCompleted} {
+		select {
+		case event := <-replay:
+			if event.ID != ids[i+1] || event.Status != want {
+				t.Errorf("replayed event %d = %s %s, want %s %s", i, event.ID, event.Status, ids[i+1], want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("replay stopped after %d events", i)
+		}
+	}
+}
+
+func TestRetryTask(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	const username = "retry_test_user"
+	newTask := func(t *testing.T) string {
+		taskID := uuid.New().String()
+		if err := testStore.AddAudioTask(taskID, username, types.AudioRequest{Audio: "https://example.com/a.wav"}, "test_queue"); err != nil {
+			t.Fatalf("AddAudioTask: %v", err)
+		}
+		t.Cleanup(func() { testStore.DeleteTask(taskID, username) })
+		return taskID
+	}
+	failed := func(t *testing.T) string {
+		taskID := newTask(t)
+		if err := testStore.UpdateTaskFailed(taskID, types.ErrorProviderError, "provider down"); err != nil {
+			t.Fatalf("UpdateTaskFailed: %v", err)
+		}
+		return taskID
+	}
+	tests := []struct {
+		name           string
+		setup          func(t *testing.T) string
+		body           string
+		expectedStatus int
+		expectedTask   types.TaskStatus
+	}{
+		{name: "Failed task", setup: failed, expectedStatus: 200, expectedTask: types.StatusQueued},
+		{name: "Failed task with overrides", setup: failed, body: `{"model":"nova-3"}`, expectedStatus: 200, expectedTask: types.StatusQueued},
+		{name: "Invalid override", setup: failed, body: `{"model":"nova 3"}`, expectedStatus: 400, expectedTask: types.StatusFailed},
+		{
+			name: "Completed task",
+			setup: func(t *testing.T) string {
+				taskID := newTask(t)
+				if _, err := testStore.StartTask(taskID); err != nil {
+					t.Fatalf("StartTask: %v", err)
+				}
+				if err := testStore.AddResultTask(taskID, types.Transcript{Text: "hello"}); err != nil {
+					t.Fatalf("AddResultTask: %v", err)
+				}
+				return taskID
+			},
+			expectedStatus: 409,
+			expectedTask:   types.StatusCompleted,
+		},
+		{
+			name: "Failed task with purged audio",
+			setup: func(t *testing.T) string {
+				taskID := failed(t)
+				if _, err := testDB.Exec("UPDATE tasks SET audio = '', audio_purged_at = NOW() WHERE task_id = $1", taskID); err != nil {
+					t.Fatalf("purge audio: %v", err)
+				}
+				return taskID
+			},
+			expectedStatus: 409,
+			expectedTask:   types.StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskID := tt.setup(t)
+			req := httptest.NewRequest("POST", "/tasks/"+taskID+"/retry", bytes.NewBufferString(tt.body))
+			req = withURLParams(asUser(req, username), "id", taskID)
+			rr := httptest.NewRecorder()
+			testHandlers.RetryTask(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			status, err := testStore.GetStatusTask(taskID)
+			if err != nil {
+				t.Fatalf("GetStatusTask: %v", err)
+			}
+			if status.Status != tt.expectedTask {
+				t.Errorf("task is %s, want %s", status.Status, tt.expectedTask)
+			}
+			if tt.expectedStatus != 200 {
+				return
+			}
+			attempt, attempts, err := testStore.GetTaskAttempts(taskID)
+			if err != nil {
+				t.Fatalf("GetTaskAttempts: %v", err)
+			}
+			if attempt != 2 || len(attempts) != 1 || attempts[0].Status != types.StatusFailed {
+				t.Errorf("attempt %d with history %+v, want attempt 2 after one failed attempt", attempt, attempts)
+			}
+		})
+	}
+}
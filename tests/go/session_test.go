@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"speechToText/src/cache"
+	"speechToText/src/config"
+	"speechToText/src/service"
+	"testing"
+)
+
+func TestSessionHandlers(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		handler        http.HandlerFunc
+		expectedStatus int
+	}{
+		{name: "List without session", method: "GET", target: "/sessions", handler: testHandlers.Sessions, expectedStatus: 401},
+		{name: "Revoke without session", method: "DELETE", target: "/sessions/abc", handler: testHandlers.RevokeSession, expectedStatus: 401},
+		{name: "Revoke all without session", method: "DELETE", target: "/sessions", handler: testHandlers.RevokeAllSessions, expectedStatus: 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		expected  string
+	}{
+		{userAgent: "", expected: "Unknown device"},
+		{userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", expected: "Firefox on Linux"},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36 Edg/126.0", expected: "Edge on Windows"},
+		{userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1", expected: "Safari on iOS"},
+		{userAgent: "Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Mobile Safari/537.36", expected: "Chrome on Android"},
+		{userAgent: "curl/8.5.0", expected: "curl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := service.DeviceName(tt.userAgent); got != tt.expected {
+				t.Errorf("DeviceName(%q) = %q, want %q", tt.userAgent, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	ctx := context.Background()
+	provider := cache.NewRedisSessionProvider(config.CurrentConfig.Redis.Host)
+	defer provider.Close()
+	if err := provider.Client.Ping(ctx).Err(); err != nil {
+		t.Skip("Redis not available")
+	}
+	manager := cache.NewRedisSessionManager("session_id", provider, 60)
+	username := "session_test_user"
+	if _, err := manager.RevokeSessions(ctx, username, ""); err != nil {
+		t.Fatalf("RevokeSessions cleanup: %v", err)
+	}
+
+	create := func(userAgent string) *cache.RedisSession {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.Header.Set("User-Agent", userAgent)
+		session, err := manager.SessionCreate(ctx, httptest.NewRecorder(), req, username, "192.0.2.1", map[string]string{"k": "v"})
+		if err != nil {
+			t.Fatalf("SessionCreate: %v", err)
+		}
+		return session
+	}
+	first := create("curl/8.5.0")
+	second := create("Firefox/128.0")
+	third := create("Chrome/126.0")
+
+	sessions, err := manager.UserSessions(ctx, username)
+	if err != nil {
+		t.Fatalf("UserSessions: %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("UserSessions returned %d sessions, want 3", len(sessions))
+	}
+	for _, session := range sessions {
+		if session.Username != username || session.IP != "192.0.2.1" || session.Values["k"] != "v" {
+			t.Errorf("session %s read back as %+v", session.Handle(), session)
+		}
+	}
+
+	if err := manager.RevokeSession(ctx, first.SessionId); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	revoked, err := manager.RevokeSessions(ctx, username, third.SessionId)
+	if err != nil {
+		t.Fatalf("RevokeSessions: %v", err)
+	}
+	if revoked != 1 {
+		t.Errorf("RevokeSessions revoked %d sessions, want 1", revoked)
+	}
+	sessions, err = manager.UserSessions(ctx, username)
+	if err != nil {
+		t.Fatalf("UserSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].SessionId != third.SessionId {
+		t.Errorf("after revoking, sessions = %+v, want only %s", sessions, third.SessionId)
+	}
+	if fields, _ := provider.Client.HGetAll(ctx, "session:"+second.SessionId).Result(); len(fields) != 0 {
+		t.Errorf("revoked session %s still stored", second.SessionId)
+	}
+	// Writes racing a revocation must not bring the session back.
+	if err := manager.Touch(ctx, second, "192.0.2.2"); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	if err := second.Set(ctx, "k", "v2"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if exists, _ := provider.Client.Exists(ctx, "session:"+second.SessionId).Result(); exists != 0 {
+		t.Errorf("writing to revoked session %s recreated it", second.SessionId)
+	}
+	if err := manager.Touch(ctx, third, "192.0.2.3"); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	if ip, _ := provider.Client.HGet(ctx, "session:"+third.SessionId, "ip").Result(); ip != "192.0.2.3" {
+		t.Errorf("touched session has ip %q, want 192.0.2.3", ip)
+	}
+	if ttl, _ := provider.Client.TTL(ctx, "session:"+third.SessionId).Result(); ttl <= 0 {
+		t.Errorf("touched session lost its TTL: %v", ttl)
+	}
+
+	if _, err := manager.RevokeSessions(ctx, username, ""); err != nil {
+		t.Fatalf("RevokeSessions cleanup: %v", err)
+	}
+}
@@ -1,20 +1,30 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"log"
 	"math"
+	"net/http"
 	"os"
+	"path/filepath"
 	"speechToText/src/api"
+	"speechToText/src/auth"
 	"speechToText/src/cache"
 	"speechToText/src/config"
-	"speechToText/src/consumer"
 	"speechToText/src/db"
+	"speechToText/src/storage"
 	"testing"
+
+	"github.com/go-chi/chi/v5"
 )
 
 var (
 	testStore    *db.Store
 	testHandlers *api.Handlers
+	// testDB lets DB tests set up states the store offers no way to reach,
+	// such as purged audio.
+	testDB *sql.DB
 )
 
 func TestMain(m *testing.M) {
@@ -23,6 +33,7 @@ func TestMain(m *testing.M) {
 		log.Printf("DB not available, DB-dependent tests will be skipped: %v", err)
 	} else {
 		testStore = db.NewStore(sqlDB)
+		testDB = sqlDB
 		defer sqlDB.Close()
 	}
 
@@ -30,10 +41,34 @@ func TestMain(m *testing.M) {
 	defer sessionProvider.Close()
 	sessionManager := cache.NewRedisSessionManager("session_id", sessionProvider, int64(math.Pow10(5)))
 
-	producer := consumer.NewProducer(config.CurrentConfig.RabbitMQ.Url)
-	defer producer.Close()
+	files, err := storage.NewLocal(filepath.Join(os.TempDir(), "speechToText-exports"))
+	if err != nil {
+		log.Fatalf("export storage init: %v", err)
+	}
+
+	tokens, err := auth.NewTokenSigner(config.CurrentConfig.Token)
+	if err != nil {
+		log.Fatalf("token keys: %v", err)
+	}
 
-	testHandlers = api.NewHandlers(testStore, sessionManager, producer)
+	testHandlers = api.NewHandlers(testStore, sessionManager, cache.NewPubSub(sessionProvider.Client), files, tokens)
 
 	os.Exit(m.Run())
 }
+
+// asUser attaches a session principal of username to the request, as the
+// auth middleware would.
+func asUser(req *http.Request, username string) *http.Request {
+	principal := auth.Principal{Username: username, Method: auth.MethodSession, SessionID: "test-session"}
+	return req.WithContext(auth.NewContext(req.Context(), principal))
+}
+
+// withURLParams sets the route parameters of the request, given as name and
+// value pairs, as the router would.
+func withURLParams(req *http.Request, pairs ...string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for i := 0; i < len(pairs); i += 2 {
+		rctx.URLParams.Add(pairs[i], pairs[i+1])
+	}
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
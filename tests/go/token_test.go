@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"speechToText/src/auth"
+	"speechToText/src/config"
+	"speechToText/src/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func tokenKey(kid string, b byte) string {
+	return kid + "=" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func newTokenSigner(t *testing.T, algorithm string, keys ...string) *auth.TokenSigner {
+	t.Helper()
+	signer, err := auth.NewTokenSigner(&config.TokenConfig{Algorithm: algorithm, Keys: keys})
+	if err != nil {
+		t.Fatalf("NewTokenSigner: %v", err)
+	}
+	return signer
+}
+
+func TestTokenSigner(t *testing.T) {
+	now := time.Now()
+	claims := auth.Claims{Subject: "testuser", ID: "jti", Family: "family", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+	oldHS := newTokenSigner(t, auth.AlgorithmHS256, tokenKey("old", 1))
+	rotatedHS := newTokenSigner(t, auth.AlgorithmHS256, tokenKey("new", 2), tokenKey("old", 1))
+	otherHS := newTokenSigner(t, auth.AlgorithmHS256, tokenKey("old", 3))
+	ed := newTokenSigner(t, auth.AlgorithmEdDSA, tokenKey("ed", 4))
+	edOld := newTokenSigner(t, auth.AlgorithmEdDSA, tokenKey("old", 1))
+
+	sign := func(signer *auth.TokenSigner, claims auth.Claims) string {
+		token, err := signer.Sign(claims)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return token
+	}
+	token := sign(oldHS, claims)
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`)) + "." + parts[2]
+	expired := claims
+	expired.ExpiresAt = now.Add(-time.Second).Unix()
+
+	tests := []struct {
+		name      string
+		signer    *auth.TokenSigner
+		token     string
+		expectErr bool
+	}{
+		{name: "HS256 round trip", signer: oldHS, token: token},
+		{name: "Old key after rotation", signer: rotatedHS, token: token},
+		{name: "EdDSA round trip", signer: ed, token: sign(ed, claims)},
+		{name: "Different secret", signer: otherHS, token: token, expectErr: true},
+		{name: "Unknown kid", signer: ed, token: sign(edOld, claims), expectErr: true},
+		{name: "Algorithm mismatch", signer: edOld, token: token, expectErr: true},
+		{name: "Tampered claims", signer: oldHS, token: tampered, expectErr: true},
+		{name: "Expired", signer: oldHS, token: sign(oldHS, expired), expectErr: true},
+		{name: "Malformed", signer: oldHS, token: "not-a-token", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.signer.Verify(tt.token, now)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != claims {
+				t.Errorf("Verify returned %+v, want %+v", got, claims)
+			}
+		})
+	}
+}
+
+func TestNewTokenSignerConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.TokenConfig
+		expectErr bool
+	}{
+		{name: "Random key", cfg: config.TokenConfig{Algorithm: auth.AlgorithmHS256}},
+		{name: "Unknown algorithm", cfg: config.TokenConfig{Algorithm: "none"}, expectErr: true},
+		{name: "Missing kid", cfg: config.TokenConfig{Algorithm: auth.AlgorithmHS256, Keys: []string{"c2VjcmV0"}}, expectErr: true},
+		{name: "Short secret", cfg: config.TokenConfig{Algorithm: auth.AlgorithmHS256, Keys: []string{"k=c2VjcmV0"}}, expectErr: true},
+		{name: "Duplicate kid", cfg: config.TokenConfig{Algorithm: auth.AlgorithmHS256, Keys: []string{tokenKey("k", 1), tokenKey("k", 2)}}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := auth.NewTokenSigner(&tt.cfg)
+			if tt.expectErr && err == nil {
+				t.Errorf("Expected error but got none")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenHandlers(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           any
+		login          bool
+		expectedStatus int
+		needsDB        bool
+	}{
+		{name: "Login with unknown mode", login: true, body: types.AuthRequest{Username: "testuser", Password: "testpass1", Mode: "jwt"}, expectedStatus: 400},
+		{name: "Refresh without token", body: types.RefreshRequest{}, expectedStatus: 400},
+		{name: "Refresh with unknown token", body: types.RefreshRequest{RefreshToken: "unknown"}, expectedStatus: 401, needsDB: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needsDB && testStore == nil {
+				t.Skip("DB not available")
+			}
+			body, _ := json.Marshal(tt.body)
+			rr := httptest.NewRecorder()
+			if tt.login {
+				testHandlers.Login(rr, httptest.NewRequest("POST", "/login", bytes.NewBuffer(body)))
+			} else {
+				testHandlers.RefreshToken(rr, httptest.NewRequest("POST", "/token/refresh", bytes.NewBuffer(body)))
+			}
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestAccessTokenFollowsAccount(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	username := "token_" + uuid.New().String()[:8]
+	if err := testStore.AddAuthData(username, "hash"); err != nil {
+		t.Fatalf("AddAuthData: %v", err)
+	}
+	defer testStore.DeleteAccount(username)
+	family, err := testStore.CreateRefreshToken(username, auth.HashToken(uuid.New().String()), time.Hour)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	signer := newTokenSigner(t, auth.AlgorithmHS256, tokenKey("k", 1))
+	now := time.Now()
+	// The token claims the admin role the account does not have (yet).
+	token, err := signer.Sign(auth.Claims{Subject: username, ID: "jti", Family: family, Role: types.RoleAdmin,
+		IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	handler := auth.NewMiddleware(nil, testStore, signer)(auth.RequireRole(types.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	tests := []struct {
+		name           string
+		change         func() error
+		expectedStatus int
+	}{
+		{name: "Role comes from the account", expectedStatus: 403},
+		{name: "Promoted account", change: func() error {
+			_, err := testStore.SetUserRole("admin", username, types.RoleAdmin)
+			return err
+		}, expectedStatus: 200},
+		{name: "Disabled account", change: func() error {
+			_, err := testStore.SetUserDisabled("admin", username, true)
+			return err
+		}, expectedStatus: 403},
+		{name: "Re-enabled account", change: func() error {
+			_, err := testStore.SetUserDisabled("admin", username, false)
+			return err
+		}, expectedStatus: 200},
+		{name: "Revoked login", change: func() error { return testStore.RevokeRefreshFamily(family) }, expectedStatus: 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.change != nil {
+				if err := tt.change(); err != nil {
+					t.Fatalf("change account: %v", err)
+				}
+			}
+			req := httptest.NewRequest("GET", "/admin/stats", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
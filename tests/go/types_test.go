@@ -108,7 +108,7 @@ func TestTaskListResponse(t *testing.T) {
 
 	response := types.TaskListResponse{
 		Tasks:      tasks,
-		Pagination: pagination,
+		Pagination: &pagination,
 	}
 
 	if len(response.Tasks) != 2 {
@@ -236,3 +236,33 @@ func TestGetResultResponse(t *testing.T) {
 		t.Errorf("Result should not be empty")
 	}
 }
+
+func TestTaskStatusTransitions(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     types.TaskStatus
+		to       types.TaskStatus
+		expected bool
+	}{
+		{name: "Queued to downloading", from: types.StatusQueued, to: types.StatusDownloading, expected: true},
+		{name: "Downloading to transcribing", from: types.StatusDownloading, to: types.StatusTranscribing, expected: true},
+		{name: "Transcribing to post processing", from: types.StatusTranscribing, to: types.StatusPostProcessing, expected: true},
+		{name: "Post processing to completed", from: types.StatusPostProcessing, to: types.StatusCompleted, expected: true},
+		{name: "Served from cache", from: types.StatusDownloading, to: types.StatusCompleted, expected: true},
+		{name: "Cancel queued task", from: types.StatusQueued, to: types.StatusCancelled, expected: true},
+		{name: "Requeue stuck task", from: types.StatusTranscribing, to: types.StatusQueued, expected: true},
+		{name: "Retry failed task", from: types.StatusFailed, to: types.StatusQueued, expected: true},
+		{name: "Skip a stage", from: types.StatusQueued, to: types.StatusCompleted, expected: false},
+		{name: "Go back a stage", from: types.StatusPostProcessing, to: types.StatusTranscribing, expected: false},
+		{name: "Cancel completed task", from: types.StatusCompleted, to: types.StatusCancelled, expected: false},
+		{name: "Retry cancelled task", from: types.StatusCancelled, to: types.StatusQueued, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.from.CanTransitionTo(tt.to); result != tt.expected {
+				t.Errorf("Expected %s -> %s allowed %v, got %v", tt.from, tt.to, tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"speechToText/src/config"
+	"speechToText/src/urlpolicy"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestURLPolicyCheck(t *testing.T) {
+	policy := urlpolicy.New(&config.URLPolicyConfig{
+		AllowedPorts: []int{80, 443},
+		AllowHosts:   []string{"media.internal", "10.1.0.0/16"},
+		DenyHosts:    []string{"*.blocked.example", "93.184.0.0/16"},
+	})
+	tests := []struct {
+		name      string
+		url       string
+		expectErr bool
+	}{
+		{name: "Public address", url: "https://8.8.8.8/audio.wav"},
+		{name: "Public IPv6 address", url: "http://[2606:4700::1111]/audio.wav"},
+		{name: "Loopback", url: "http://127.0.0.1/audio.wav", expectErr: true},
+		{name: "IPv6 loopback", url: "http://[::1]/audio.wav", expectErr: true},
+		{name: "IPv4-mapped loopback", url: "http://[::ffff:127.0.0.1]/audio.wav", expectErr: true},
+		{name: "Metadata endpoint", url: "http://169.254.169.254/latest/meta-data/", expectErr: true},
+		{name: "Private network", url: "http://192.168.1.10/audio.wav", expectErr: true},
+		{name: "Shared address space", url: "http://100.100.100.200/audio.wav", expectErr: true},
+		{name: "Unspecified", url: "http://0.0.0.0/audio.wav", expectErr: true},
+		{name: "Decimal address", url: "http://2130706433/audio.wav", expectErr: true},
+		{name: "Hex address", url: "http://0x7f.0x1/audio.wav", expectErr: true},
+		{name: "Disallowed port", url: "https://8.8.8.8:8080/audio.wav", expectErr: true},
+		{name: "Other scheme", url: "ftp://8.8.8.8/audio.wav", expectErr: true},
+		{name: "Denied host", url: "https://cdn.blocked.example/audio.wav", expectErr: true},
+		{name: "Denied range", url: "https://93.184.216.34/audio.wav", expectErr: true},
+		{name: "Allowed host", url: "http://media.internal/audio.wav"},
+		{name: "Allowed range", url: "http://10.1.2.3/audio.wav"},
+		{name: "Outside allowed range", url: "http://10.2.0.1/audio.wav", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(context.Background(), tt.url)
+			if tt.expectErr && !errors.Is(err, urlpolicy.ErrBlocked) {
+				t.Errorf("Expected ErrBlocked, got %v", err)
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestURLPolicyClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://localhost:"+r.URL.Port()+"/audio.wav", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	tests := []struct {
+		name       string
+		allowHosts []string
+		path       string
+		expectErr  bool
+	}{
+		{name: "Loopback server", path: "/audio.wav", expectErr: true},
+		{name: "Allowed server", allowHosts: []string{"127.0.0.1"}, path: "/audio.wav"},
+		{name: "Redirect to loopback", allowHosts: []string{"127.0.0.1"}, path: "/redirect", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := urlpolicy.New(&config.URLPolicyConfig{AllowedPorts: []int{port}, AllowHosts: tt.allowHosts})
+			resp, err := policy.Client(5 * time.Second).Get(server.URL + tt.path)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if tt.expectErr && !errors.Is(err, urlpolicy.ErrBlocked) {
+				t.Errorf("Expected ErrBlocked, got %v", err)
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"speechToText/src/config"
+	"speechToText/src/types"
+	"speechToText/src/urlpolicy"
+	"speechToText/src/webhook"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestWebhookSign(t *testing.T) {
+	body := []byte(`{"event":"task.completed"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := webhook.Sign("secret", 1700000000, body); got != expected {
+		t.Errorf("Sign returned %s, want %s", got, expected)
+	}
+	if webhook.Sign("other", 1700000000, body) == expected {
+		t.Errorf("Signature should depend on the secret")
+	}
+	if webhook.Sign("secret", 1700000001, body) == expected {
+		t.Errorf("Signature should depend on the timestamp")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 0, expected: 10 * time.Second},
+		{attempts: 1, expected: 20 * time.Second},
+		{attempts: 3, expected: 80 * time.Second},
+		{attempts: 10, expected: time.Hour},
+		{attempts: 100, expected: time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := webhook.Backoff(tt.attempts); got != tt.expected {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.expected)
+		}
+	}
+}
+
+func TestCreateWebhook(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "Invalid JSON", body: `{"url":`, expectedStatus: 400},
+		{name: "Missing URL", body: `{}`, expectedStatus: 400},
+		{name: "Other scheme", body: `{"url":"ftp://8.8.8.8/hook"}`, expectedStatus: 400},
+		{name: "Unknown event", body: `{"url":"https://8.8.8.8/hook","events":["task.deleted"]}`, expectedStatus: 400},
+		{name: "Loopback address", body: `{"url":"http://127.0.0.1/hook"}`, expectedStatus: 400},
+		{name: "Localhost", body: `{"url":"http://localhost/hook"}`, expectedStatus: 400},
+		{name: "Metadata endpoint", body: `{"url":"http://169.254.169.254/latest/meta-data/"}`, expectedStatus: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := asUser(httptest.NewRequest("POST", "/webhooks", bytes.NewBufferString(tt.body)), "testuser")
+			rr := httptest.NewRecorder()
+			testHandlers.CreateWebhook(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestWebhookSecrets(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	username := "webhook_" + uuid.New().String()[:8]
+	if err := testStore.AddAuthData(username, "hash"); err != nil {
+		t.Fatalf("AddAuthData: %v", err)
+	}
+	defer testStore.DeleteAccount(username)
+	created, err := testStore.CreateWebhook(username, "https://8.8.8.8/hook", types.WebhookEvents)
+	if err != nil || created.Secret == "" {
+		t.Fatalf("Expected a secret from CreateWebhook, got %+v, %v", created, err)
+	}
+
+	rr := httptest.NewRecorder()
+	testHandlers.Webhooks(rr, asUser(httptest.NewRequest("GET", "/webhooks", nil), username))
+	if rr.Code != 200 {
+		t.Fatalf("handler returned wrong status code: got %v want 200", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), created.Secret) || strings.Contains(rr.Body.String(), `"secret"`) {
+		t.Errorf("GET /webhooks exposes a signing secret: %s", rr.Body)
+	}
+
+	rotate := func() string {
+		rr := httptest.NewRecorder()
+		testHandlers.RotateCallbackSecret(rr, asUser(httptest.NewRequest("POST", "/webhooks/callback-secret", nil), username))
+		if rr.Code != 200 {
+			t.Fatalf("handler returned wrong status code: got %v want 200", rr.Code)
+		}
+		var response types.CallbackSecretResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return response.Secret
+	}
+	first, second := rotate(), rotate()
+	if first == "" || first == second {
+		t.Errorf("Expected a new callback secret on every rotation, got %q and %q", first, second)
+	}
+}
+
+func TestAudioCallbackURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		callbackURL string
+	}{
+		{name: "Loopback address", callbackURL: "http://127.0.0.1/hook"},
+		{name: "IPv6 loopback", callbackURL: "http://[::1]/hook"},
+		{name: "Private network", callbackURL: "https://10.0.0.5/hook"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(types.AudioRequest{Audio: "https://8.8.8.8/a.wav", CallbackURL: tt.callbackURL})
+			req := asUser(httptest.NewRequest("POST", "/audio", bytes.NewBuffer(body)), "testuser")
+			rr := httptest.NewRecorder()
+			testHandlers.Audio(rr, req)
+
+			if status := rr.Code; status != 400 {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, 400)
+			}
+		})
+	}
+}
+
+func TestDispatcherRefusesLoopback(t *testing.T) {
+	if testStore == nil {
+		t.Skip("DB not available")
+	}
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	username := "webhook_" + uuid.New().String()[:8]
+	if err := testStore.AddAuthData(username, "hash"); err != nil {
+		t.Fatalf("AddAuthData: %v", err)
+	}
+	defer testStore.DeleteAccount(username)
+	// The store does not validate the URL, so this stands in for an endpoint
+	// that passed registration and resolves to loopback later.
+	taskID := uuid.New().String()
+	request := types.AudioRequest{Audio: "https://example.com/a.wav", CallbackURL: server.URL + "/hook"}
+	if err := testStore.AddAudioTask(taskID, username, request, "test_queue"); err != nil {
+		t.Fatalf("AddAudioTask: %v", err)
+	}
+	if err := testStore.UpdateTaskFailed(taskID, types.ErrorProviderError, "provider down"); err != nil {
+		t.Fatalf("UpdateTaskFailed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		webhook.NewDispatcher(testStore, &config.WebhookConfig{
+			PollInterval: 100 * time.Millisecond, BatchSize: 100, MaxAttempts: 5, Timeout: 5 * time.Second,
+		}).Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		deliveries, err := testStore.ListWebhookDeliveries(username, taskID, 10)
+		if err != nil {
+			t.Fatalf("ListWebhookDeliveries: %v", err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Attempts > 0 {
+			if deliveries[0].Status == "delivered" || !strings.Contains(deliveries[0].LastError, urlpolicy.ErrBlocked.Error()) {
+				t.Errorf("delivery to loopback ended %s with %q, want it blocked", deliveries[0].Status, deliveries[0].LastError)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no delivery attempt recorded, deliveries = %+v", deliveries)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("loopback endpoint was called %d times", n)
+	}
+}